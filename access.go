@@ -0,0 +1,35 @@
+package smt
+
+// accessLog is a ring buffer of the most recently accessed keys.
+type accessLog struct {
+	keys [][]byte
+	next int
+	full bool
+}
+
+func newAccessLog(n int) *accessLog {
+	return &accessLog{keys: make([][]byte, n)}
+}
+
+func (al *accessLog) record(key []byte) {
+	al.keys[al.next] = append([]byte(nil), key...)
+	al.next = (al.next + 1) % len(al.keys)
+	if al.next == 0 {
+		al.full = true
+	}
+}
+
+// RecentKeys returns the most recently accessed keys, oldest first, if the
+// tree was created with WithAccessTracking. A key accessed several times
+// appears once per access.
+func (smt *SparseMerkleTree) RecentKeys() [][]byte {
+	if smt.accessLog == nil {
+		return nil
+	}
+	al := smt.accessLog
+	var keys [][]byte
+	if al.full {
+		keys = append(keys, al.keys[al.next:]...)
+	}
+	return append(keys, al.keys[:al.next]...)
+}
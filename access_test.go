@@ -0,0 +1,38 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestRecentKeys(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if smt.RecentKeys() != nil {
+		t.Error("recent keys returned without access tracking")
+	}
+
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithAccessTracking(3))
+	smt.Update([]byte("0"), []byte("0"))
+	smt.Get([]byte("1"))
+	keys := smt.RecentKeys()
+	if len(keys) != 2 || !bytes.Equal(keys[0], []byte("0")) || !bytes.Equal(keys[1], []byte("1")) {
+		t.Errorf("unexpected recent keys: %q", keys)
+	}
+
+	for i := 2; i < 10; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	keys = smt.RecentKeys()
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 recent keys, got: %d", len(keys))
+	}
+	for i, key := range keys {
+		if !bytes.Equal(key, []byte(strconv.Itoa(7+i))) {
+			t.Errorf("unexpected recent keys: %q", keys)
+		}
+	}
+}
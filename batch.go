@@ -0,0 +1,63 @@
+package smt
+
+// BatchWriter accumulates writes and deletes to a store, to be applied
+// together when committed.
+type BatchWriter interface {
+	Set(key []byte, value []byte) error // Set adds an update of the value for a key to the batch.
+	Delete(key []byte) error            // Delete adds a deletion of a key to the batch.
+	Commit() error                      // Commit applies the batch.
+	Discard()                           // Discard drops the operations added since the last commit.
+}
+
+type batchOp struct {
+	key, value []byte
+	delete     bool
+}
+
+// MapStoreBatch is a BatchWriter that applies its operations to a MapStore
+// sequentially when committed.
+type MapStoreBatch struct {
+	store MapStore
+	ops   []batchOp
+}
+
+// NewMapStoreBatch creates a new empty MapStoreBatch for a MapStore.
+func NewMapStoreBatch(store MapStore) *MapStoreBatch {
+	return &MapStoreBatch{store: store}
+}
+
+// Set adds an update of the value for a key to the batch.
+func (b *MapStoreBatch) Set(key []byte, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+	return nil
+}
+
+// Delete adds a deletion of a key to the batch.
+func (b *MapStoreBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+	return nil
+}
+
+// Commit applies the operations of the batch to the MapStore in order, and
+// empties the batch.
+func (b *MapStoreBatch) Commit() error {
+	ops := b.ops
+	b.ops = nil
+	for _, op := range ops {
+		var err error
+		if op.delete {
+			err = b.store.Delete(op.key)
+		} else {
+			err = b.store.Set(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard empties the batch without applying its operations.
+func (b *MapStoreBatch) Discard() {
+	b.ops = nil
+}
@@ -0,0 +1,119 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// countingBatch is a BatchWriter for tests that counts commits.
+type countingBatch struct {
+	*MapStoreBatch
+	commits int
+}
+
+func (b *countingBatch) Commit() error {
+	b.commits++
+	return b.MapStoreBatch.Commit()
+}
+
+func TestBatchWriter(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+	batchSmn := NewSimpleMap()
+	batch := &countingBatch{MapStoreBatch: NewMapStoreBatch(batchSmn)}
+	batched := NewSparseMerkleTree(batchSmn, NewSimpleMap(), sha256.New(), WithBatchWriter(batch))
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i % 30))
+		var root, batchedRoot []byte
+		var err error
+		if i%4 == 3 {
+			root, _ = smt.Delete(key)
+			batchedRoot, err = batched.Delete(key)
+		} else {
+			root, _ = smt.Update(key, []byte(strconv.Itoa(i)))
+			batchedRoot, err = batched.Update(key, []byte(strconv.Itoa(i)))
+		}
+		if err != nil {
+			t.Fatalf("returned error when updating batched tree: %v", err)
+		}
+		if !bytes.Equal(root, batchedRoot) {
+			t.Fatal("batched tree root does not match unbatched tree root")
+		}
+		if len(batch.ops) != 0 {
+			t.Fatal("batch was not committed at the end of the operation")
+		}
+	}
+
+	if len(smn.m) != len(batchSmn.m) {
+		t.Errorf("expected %d nodes in batched store, got: %d", len(smn.m), len(batchSmn.m))
+	}
+	for digest, data := range smn.m {
+		if !bytes.Equal(batchSmn.m[digest], data) {
+			t.Error("batched store does not match unbatched store")
+		}
+	}
+	if batch.commits > 100 {
+		t.Errorf("expected at most one commit per operation, got: %d", batch.commits)
+	}
+}
+
+// failOnceMapStore is a MapStore for tests whose n-th next write fails, once
+// failIn is set to n.
+type failOnceMapStore struct {
+	MapStore
+	failIn int
+}
+
+var errFailOnce = errors.New("write failed")
+
+func (s *failOnceMapStore) Set(key []byte, value []byte) error {
+	if s.failIn > 0 {
+		s.failIn--
+		if s.failIn == 0 {
+			return errFailOnce
+		}
+	}
+	return s.MapStore.Set(key, value)
+}
+
+// Test that the writes of a failed operation are discarded from the batch.
+func TestBatchWriterDiscardOnError(t *testing.T) {
+	smn := NewSimpleMap()
+	smv := &failOnceMapStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithBatchWriter(NewMapStoreBatch(smn)))
+
+	for i := 0; i < 10; i++ {
+		if _, err := smt.Update([]byte(strconv.Itoa(i)), []byte("testValue")); err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+	}
+	root := smt.Root()
+	smv.failIn = 1
+	if _, err := smt.Update([]byte("3"), []byte("testValue2")); err != errFailOnce {
+		t.Fatalf("did not return error of values store: %v", err)
+	}
+	if !bytes.Equal(root, smt.Root()) {
+		t.Error("tree root changed after failed update")
+	}
+	if _, err := smt.Update([]byte("10"), []byte("testValue")); err != nil {
+		t.Fatalf("returned error when updating key: %v", err)
+	}
+
+	if err := smt.ValidateAgainstStore(); err != nil {
+		t.Errorf("tree is inconsistent with the store after failed update: %v", err)
+	}
+	for i := 0; i <= 10; i++ {
+		key := []byte(strconv.Itoa(i))
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), key, []byte("testValue"), sha256.New()) {
+			t.Error("valid proof failed to verify after failed update")
+		}
+	}
+}
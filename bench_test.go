@@ -2,6 +2,7 @@ package smt
 
 import (
 	"crypto/sha256"
+	"runtime"
 	"strconv"
 	"testing"
 )
@@ -34,3 +35,84 @@ func BenchmarkSparseMerkleTree_Delete(b *testing.B) {
 		_, _ = smt.Delete([]byte(s))
 	}
 }
+
+// countingMapStore is a MapStore for benchmarks that counts calls to Set and
+// Delete.
+type countingMapStore struct {
+	MapStore
+	calls int
+}
+
+func (s *countingMapStore) Set(key []byte, value []byte) error {
+	s.calls++
+	return s.MapStore.Set(key, value)
+}
+
+func (s *countingMapStore) Delete(key []byte) error {
+	s.calls++
+	return s.MapStore.Delete(key)
+}
+
+func BenchmarkSparseMerkleTree_UpdateBatchWriter(b *testing.B) {
+	for _, batched := range []bool{false, true} {
+		b.Run("batched="+strconv.FormatBool(batched), func(b *testing.B) {
+			smn := &countingMapStore{MapStore: NewSimpleMap()}
+			var options []Option
+			batch := &countingBatch{MapStoreBatch: NewMapStoreBatch(smn)}
+			if batched {
+				options = append(options, WithBatchWriter(batch))
+			}
+			smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), options...)
+			for i := 0; i < 100000; i++ {
+				s := strconv.Itoa(i)
+				_, _ = smt.Update([]byte(s), []byte(s))
+			}
+			smn.calls, batch.commits = 0, 0
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s := strconv.Itoa(i)
+				_, _ = smt.Update([]byte(s), []byte(s+"-"))
+			}
+			// Without batching every store call is a round trip; with
+			// batching there is one per commit.
+			roundTrips := smn.calls
+			if batched {
+				roundTrips = batch.commits
+			}
+			b.ReportMetric(float64(roundTrips)/float64(b.N), "roundtrips/op")
+		})
+	}
+}
+
+func BenchmarkBuildSMT(b *testing.B) {
+	kvs := make([]KVPair, 10000)
+	for i := range kvs {
+		s := []byte(strconv.Itoa(i))
+		kvs[i] = KVPair{Key: s, Value: s}
+	}
+
+	b.Run("build", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs)
+		}
+	})
+	b.Run("build-parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			smn, smv := NewSyncMapStore(NewSimpleMap()), NewSyncMapStore(NewSimpleMap())
+			_, _ = BuildSMT(smn, smv, sha256.New(), kvs, WithParallelSave(runtime.NumCPU()))
+		}
+	})
+	b.Run("update", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+			for _, kv := range kvs {
+				_, _ = smt.Update(kv.Key, kv.Value)
+			}
+		}
+	})
+}
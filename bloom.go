@@ -0,0 +1,107 @@
+package smt
+
+import (
+	"bytes"
+	"hash/fnv"
+)
+
+// absenceBloomHashes is the number of bits set in an absenceBloom for each
+// path.
+const absenceBloomHashes = 4
+
+// absenceBloom is a bloom filter of the paths of the leaves of a tree, used
+// to answer that a key is absent without reading the stores. It is only valid
+// for the root it was last updated for.
+type absenceBloom struct {
+	bits []uint64
+	size uint64
+	root []byte
+}
+
+func newAbsenceBloom(size int) *absenceBloom {
+	return &absenceBloom{
+		bits: make([]uint64, (size+63)/64),
+		size: uint64(size),
+	}
+}
+
+// positions returns the bits of a path, by double hashing.
+func (ab *absenceBloom) positions(path []byte) [absenceBloomHashes]uint64 {
+	h := fnv.New64a()
+	h.Write(path)
+	h1 := h.Sum64()
+	h.Write([]byte{0})
+	h2 := h.Sum64() | 1
+	var positions [absenceBloomHashes]uint64
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % ab.size
+	}
+	return positions
+}
+
+func (ab *absenceBloom) add(path []byte) {
+	for _, position := range ab.positions(path) {
+		ab.bits[position/64] |= 1 << (position % 64)
+	}
+}
+
+// mayContain returns false if the path is definitely not in the filter.
+func (ab *absenceBloom) mayContain(path []byte) bool {
+	for _, position := range ab.positions(path) {
+		if ab.bits[position/64]&(1<<(position%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (ab *absenceBloom) clone() *absenceBloom {
+	return &absenceBloom{
+		bits: append([]uint64(nil), ab.bits...),
+		size: ab.size,
+		root: ab.root,
+	}
+}
+
+// bloomValid returns true if the tree has an absence bloom filter valid for
+// its current root.
+func (smt *SparseMerkleTree) bloomValid() bool {
+	return smt.absenceBloom != nil && bytes.Equal(smt.absenceBloom.root, smt.root)
+}
+
+// updateBloom adds the path of a key set to a value to the absence bloom
+// filter, if it is valid, and makes it valid for the new root.
+func (smt *SparseMerkleTree) updateBloom(key []byte, value []byte, newRoot []byte) {
+	if !smt.bloomValid() {
+		return
+	}
+	if !smt.th.isDefault(value) {
+		smt.absenceBloom.add(smt.th.path(key))
+	}
+	smt.absenceBloom.root = newRoot
+}
+
+// RebuildAbsenceBloom fills the bloom filter of a tree created with
+// WithAbsenceBloom with the paths of all its leaves, by walking the tree. The
+// filter is only used while the root of the tree is changed by Update and its
+// variants, so it must be rebuilt after the root is set by other means, e.g.
+// after ImportSparseMerkleTree or SetRoot.
+func (smt *SparseMerkleTree) RebuildAbsenceBloom() error {
+	if smt.absenceBloom == nil {
+		return nil
+	}
+	if smt.closed {
+		return ErrClosed
+	}
+	bloom := newAbsenceBloom(int(smt.absenceBloom.size))
+	err := smt.forEachLeaf(smt.root, 0, nil, func(path, valueHash []byte) error {
+		bloom.add(path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	bloom.root = smt.root
+	smt.absenceBloom = bloom
+	return nil
+}
@@ -0,0 +1,96 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestAbsenceBloom(t *testing.T) {
+	tracer, smv := NewTracingMapStore(NewSimpleMap())
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithAbsenceBloom(1<<16))
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	smt.Delete([]byte("3"))
+
+	// Present keys are looked up as usual.
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		value, err := smt.Get([]byte(s))
+		if err != nil {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if i == 3 {
+			s = ""
+		}
+		if !bytes.Equal(value, []byte(s)) {
+			t.Error("got incorrect value with absence bloom")
+		}
+	}
+
+	// Most absent keys do not touch the values MapStore.
+	tracer.Reset()
+	for i := 0; i < 100; i++ {
+		has, err := smt.Has([]byte("absent" + strconv.Itoa(i)))
+		if err != nil || has {
+			t.Error("absent key reported as present")
+		}
+	}
+	if len(tracer.Trace()) > 10 {
+		t.Errorf("absent keys caused %d reads of the values MapStore", len(tracer.Trace()))
+	}
+
+	// An imported tree does not use the filter until it is rebuilt.
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), smt.Root(), WithAbsenceBloom(1<<16))
+	if imported.bloomValid() {
+		t.Error("absence bloom is valid for imported tree")
+	}
+	value, _ := imported.Get([]byte("5"))
+	if !bytes.Equal(value, []byte("5")) {
+		t.Error("got incorrect value from imported tree")
+	}
+	if err := imported.RebuildAbsenceBloom(); err != nil {
+		t.Errorf("returned error when rebuilding absence bloom: %v", err)
+	}
+	if !imported.bloomValid() {
+		t.Error("absence bloom is not valid after rebuilding")
+	}
+	tracer.Reset()
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		if has, _ := imported.Has([]byte(s)); has != (i != 3) {
+			t.Error("got incorrect presence from rebuilt absence bloom")
+		}
+		imported.Has([]byte("absent" + s))
+	}
+	if len(tracer.Trace()) > 60 {
+		t.Errorf("absent keys caused %d reads of the values MapStore", len(tracer.Trace())-49)
+	}
+
+	// Setting the root by other means invalidates the filter.
+	imported.SetRoot(nil)
+	if imported.bloomValid() {
+		t.Error("absence bloom is valid after setting root")
+	}
+
+	// Trees built in bulk fill the filter.
+	var kvs []KVPair
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		kvs = append(kvs, KVPair{Key: []byte(s), Value: []byte(s)})
+	}
+	built, _ := BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs, WithAbsenceBloom(1<<16))
+	if !built.bloomValid() {
+		t.Error("absence bloom is not valid for built tree")
+	}
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		if has, _ := built.Has([]byte(s)); !has {
+			t.Error("key of built tree reported as absent")
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"sync"
+)
+
+// ErrConcurrentStoreRequired is returned by BuildSMT for a tree created with
+// WithParallelSave, if a MapStore written concurrently does not implement
+// ConcurrentMapStore.
+var ErrConcurrentStoreRequired = errors.New("store is not safe for concurrent use")
+
+// KVPair is a key and its value.
+type KVPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// DuplicateKeyError is returned by BuildSMT for a tree created with
+// WithRejectDuplicatesInBatch, when two pairs have keys with the same path.
+type DuplicateKeyError struct {
+	Key []byte
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key: %x", e.Key)
+}
+
+// buildLeaf is a leaf to be built, identified by its path.
+type buildLeaf struct {
+	key   []byte
+	path  []byte
+	value []byte
+}
+
+// BuildSMT creates a Sparse Merkle tree containing the given key-value pairs,
+// on an empty MapStore. The leaves are sorted by path and the tree is built
+// bottom-up, writing each node once, which is much faster than updating the
+// keys one at a time; the root is the same. If several keys have the same
+// path, e.g. the same key appears several times, the value of the last one is
+// used, unless the tree is created with WithRejectDuplicatesInBatch. Pairs with
+// the default value are ignored.
+func BuildSMT(nodes, values MapStore, hasher hash.Hash, kvs []KVPair, options ...Option) (*SparseMerkleTree, error) {
+	smt := NewSparseMerkleTree(nodes, values, hasher, options...)
+
+	leaves := make([]buildLeaf, 0, len(kvs))
+	for _, kv := range kvs {
+		if smt.th.isDefault(kv.Value) {
+			continue
+		}
+		path := smt.th.path(kv.Key)
+		if len(path) != smt.th.pathSize() {
+			return nil, ErrInvalidPath
+		}
+		leaves = append(leaves, buildLeaf{key: kv.Key, path: path, value: smt.versionValue(kv.Value)})
+		smt.advanceVersion()
+	}
+	// Sort stably, so that the last value of a duplicate key can be kept.
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i].path, leaves[j].path) < 0
+	})
+	deduped := leaves[:0]
+	for i, leaf := range leaves {
+		if i+1 < len(leaves) && bytes.Equal(leaf.path, leaves[i+1].path) {
+			if smt.rejectDuplicates {
+				return nil, &DuplicateKeyError{Key: leaves[i+1].key}
+			}
+			continue
+		}
+		deduped = append(deduped, leaf)
+	}
+
+	var w *parallelWriter
+	if smt.saveWorkers > 1 {
+		if _, ok := smt.values.(ConcurrentMapStore); !ok {
+			return nil, ErrConcurrentStoreRequired
+		}
+		if _, ok := smt.nodes.(ConcurrentMapStore); !ok && smt.batch == nil {
+			return nil, ErrConcurrentStoreRequired
+		}
+		w = newParallelWriter(smt.saveWorkers)
+	}
+	root, err := smt.buildSubtree(w, deduped, 0)
+	if w != nil {
+		if waitErr := w.wait(); err == nil {
+			err = waitErr
+		}
+	}
+	if err == nil {
+		err = smt.commitNodes()
+	}
+	if err != nil {
+		smt.discardNodes()
+		return nil, err
+	}
+	if smt.absenceBloom != nil {
+		for _, leaf := range deduped {
+			smt.absenceBloom.add(leaf.path)
+		}
+		smt.absenceBloom.root = root
+	}
+	smt.SetRoot(root)
+	return smt, nil
+}
+
+// parallelWrite is a write to a MapStore queued by a parallelWriter.
+type parallelWrite struct {
+	store      MapStore
+	key, value []byte
+}
+
+// parallelWriter performs writes to MapStores from several goroutines.
+type parallelWriter struct {
+	writes chan parallelWrite
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	err    error
+}
+
+func newParallelWriter(workers int) *parallelWriter {
+	w := &parallelWriter{writes: make(chan parallelWrite, workers*64)}
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer w.wg.Done()
+			for write := range w.writes {
+				if w.failed() {
+					// Drain the remaining writes.
+					continue
+				}
+				if err := write.store.Set(write.key, write.value); err != nil {
+					w.mu.Lock()
+					w.err = err
+					w.mu.Unlock()
+				}
+			}
+		}()
+	}
+	return w
+}
+
+func (w *parallelWriter) failed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
+}
+
+// set queues a write. It returns the error of a failed write, if any, so that
+// the build stops early.
+func (w *parallelWriter) set(store MapStore, key, value []byte) error {
+	w.mu.Lock()
+	err := w.err
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	w.writes <- parallelWrite{store: store, key: key, value: value}
+	return nil
+}
+
+// wait waits for the queued writes to be performed, and returns the error of
+// the first failed one, if any. No writes may be queued afterwards.
+func (w *parallelWriter) wait() error {
+	close(w.writes)
+	w.wg.Wait()
+	return w.err
+}
+
+// buildSetNode writes a node built by BuildSMT, through w if it is not nil.
+func (smt *SparseMerkleTree) buildSetNode(w *parallelWriter, digest, data []byte) error {
+	if w == nil || smt.batch != nil {
+		return smt.setNode(digest, data)
+	}
+	if err := w.set(smt.nodes, digest, data); err != nil {
+		return err
+	}
+	if smt.persistCallback != nil {
+		smt.persistCallback(digest, data)
+	}
+	return nil
+}
+
+// buildSubtree writes the subtree at the given depth containing the given
+// leaves, which are sorted by path, and returns its root. The writes are
+// performed through w if it is not nil.
+func (smt *SparseMerkleTree) buildSubtree(w *parallelWriter, leaves []buildLeaf, depth int) ([]byte, error) {
+	switch len(leaves) {
+	case 0:
+		return smt.th.placeholder(), nil
+	case 1:
+		valueHash := smt.th.digest(leaves[0].value)
+		leafHash, leafData := smt.th.digestLeaf(leaves[0].path, valueHash)
+		if err := smt.buildSetNode(w, leafHash, leafData); err != nil {
+			return nil, err
+		}
+		var err error
+		if w != nil {
+			err = w.set(smt.values, leaves[0].path, leaves[0].value)
+		} else {
+			err = smt.values.Set(leaves[0].path, leaves[0].value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return leafHash, nil
+	}
+
+	// The leaves with the bit at this depth off come first.
+	split := sort.Search(len(leaves), func(i int) bool {
+		return getBitAtFromMSB(leaves[i].path, depth) == right
+	})
+	leftNode, err := smt.buildSubtree(w, leaves[:split], depth+1)
+	if err != nil {
+		return nil, err
+	}
+	rightNode, err := smt.buildSubtree(w, leaves[split:], depth+1)
+	if err != nil {
+		return nil, err
+	}
+	currentHash, currentData := smt.th.digestNode(leftNode, rightNode, depth)
+	if err := smt.buildSetNode(w, currentHash, currentData); err != nil {
+		return nil, err
+	}
+	return currentHash, nil
+}
@@ -0,0 +1,132 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestBuildSMT(t *testing.T) {
+	for _, numKeys := range []int{0, 1, 2, 200} {
+		var kvs []KVPair
+		smn, smv := NewSimpleMap(), NewSimpleMap()
+		expected := NewSparseMerkleTree(smn, smv, sha256.New())
+		for i := 0; i < numKeys; i++ {
+			key := []byte(strconv.Itoa(rand.Intn(numKeys)))
+			value := []byte(strconv.Itoa(rand.Int()))
+			if rand.Intn(10) == 0 {
+				value = defaultValue
+			}
+			kvs = append(kvs, KVPair{Key: key, Value: value})
+			if !bytes.Equal(value, defaultValue) {
+				expected.Update(key, value)
+			}
+		}
+
+		nodes, values := NewSimpleMap(), NewSimpleMap()
+		smt, err := BuildSMT(nodes, values, sha256.New(), kvs)
+		if err != nil {
+			t.Fatalf("returned error when building tree: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), expected.Root()) {
+			t.Errorf("built root does not match for %d keys", numKeys)
+		}
+		if len(nodes.m) != len(smn.m) || len(values.m) != len(smv.m) {
+			t.Error("built tree does not have the expected nodes and values")
+		}
+		for _, kv := range kvs {
+			value, _ := smt.Get(kv.Key)
+			expectedValue, _ := expected.Get(kv.Key)
+			if !bytes.Equal(value, expectedValue) {
+				t.Error("built tree does not have the expected value")
+			}
+		}
+	}
+}
+
+func TestBuildSMTDuplicates(t *testing.T) {
+	kvs := []KVPair{
+		{Key: []byte("testKey"), Value: []byte("testValue")},
+		{Key: []byte("testKey2"), Value: []byte("testValue2")},
+		{Key: []byte("testKey"), Value: []byte("testValue3")},
+	}
+	smt, err := BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs)
+	if err != nil {
+		t.Errorf("returned error when building tree with duplicate keys: %v", err)
+	}
+	value, _ := smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("testValue3")) {
+		t.Error("duplicate key did not get last value")
+	}
+
+	_, err = BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs, WithRejectDuplicatesInBatch())
+	var duplicateKeyError *DuplicateKeyError
+	if !errors.As(err, &duplicateKeyError) || !bytes.Equal(duplicateKeyError.Key, []byte("testKey")) {
+		t.Errorf("expected DuplicateKeyError for duplicate key, got: %v", err)
+	}
+
+	// Distinct keys whose paths collide are duplicates too.
+	th := newVerifierTreeHasher(sha256.New(), []Option{WithPathLength(1)})
+	seen := make(map[byte][]byte)
+	kvs = nil
+	for i := 0; len(kvs) < 2; i++ {
+		key := []byte(strconv.Itoa(i))
+		if other, ok := seen[th.path(key)[0]]; ok {
+			kvs = []KVPair{{Key: other, Value: []byte("value1")}, {Key: key, Value: []byte("value2")}}
+		}
+		seen[th.path(key)[0]] = key
+	}
+	smt, err = BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs, WithPathLength(1))
+	if err != nil {
+		t.Errorf("returned error when building tree with colliding keys: %v", err)
+	}
+	value, _ = smt.Get(kvs[0].Key)
+	if !bytes.Equal(value, []byte("value2")) {
+		t.Error("colliding key did not get last value")
+	}
+	_, err = BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs, WithPathLength(1), WithRejectDuplicatesInBatch())
+	if !errors.As(err, &duplicateKeyError) || !bytes.Equal(duplicateKeyError.Key, kvs[1].Key) {
+		t.Errorf("expected DuplicateKeyError for colliding key, got: %v", err)
+	}
+}
+
+func TestBuildSMTParallelSave(t *testing.T) {
+	kvs := make([]KVPair, 1000)
+	for i := range kvs {
+		s := []byte(strconv.Itoa(i))
+		kvs[i] = KVPair{Key: s, Value: s}
+	}
+	expected, err := BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs)
+	if err != nil {
+		t.Fatalf("returned error when building tree: %v", err)
+	}
+
+	smn, smv := NewSyncMapStore(NewSimpleMap()), NewSyncMapStore(NewSimpleMap())
+	smt, err := BuildSMT(smn, smv, sha256.New(), kvs, WithParallelSave(4))
+	if err != nil {
+		t.Fatalf("returned error when building tree in parallel: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root of tree built in parallel does not match")
+	}
+	for _, kv := range kvs {
+		value, err := smt.Get(kv.Key)
+		if err != nil {
+			t.Fatalf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, kv.Value) {
+			t.Errorf("did not get correct value for key %s", kv.Key)
+		}
+	}
+	if err := smt.ValidateAgainstStore(); err != nil {
+		t.Errorf("tree built in parallel does not validate: %v", err)
+	}
+
+	_, err = BuildSMT(NewSimpleMap(), smv, sha256.New(), kvs, WithParallelSave(4))
+	if err != ErrConcurrentStoreRequired {
+		t.Errorf("did not return ErrConcurrentStoreRequired for unsafe store: %v", err)
+	}
+}
@@ -134,3 +134,43 @@ func bulkCheckAll(t *testing.T, smt *SparseMerkleTree, kv *map[string]string) {
 		}
 	}
 }
+
+// Test that deleting keys leaves no redundant chains of inner nodes, by
+// comparing the tree with one built from the remaining keys.
+func TestSparseMerkleTreeDeleteCollapse(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		smn := NewSimpleMap()
+		smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+		kv := make(map[string]string)
+		for j := 0; j < 1+rand.Intn(50); j++ {
+			key, value := make([]byte, 8), make([]byte, 8)
+			rand.Read(key)
+			rand.Read(value)
+			smt.Update(key, value)
+			kv[string(key)] = string(value)
+		}
+
+		for key := range kv {
+			if rand.Intn(2) == 0 {
+				continue
+			}
+			smt.Delete([]byte(key))
+			delete(kv, key)
+
+			var kvs []KVPair
+			for k, v := range kv {
+				kvs = append(kvs, KVPair{Key: []byte(k), Value: []byte(v)})
+			}
+			built, err := BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs)
+			if err != nil {
+				t.Fatalf("returned error when building tree: %v", err)
+			}
+			if !bytes.Equal(smt.Root(), built.Root()) {
+				t.Fatal("root after delete does not match rebuilt root")
+			}
+			if len(smn.m) != len(built.nodes.(*SimpleMap).m) {
+				t.Fatal("nodes after delete do not match rebuilt nodes")
+			}
+		}
+	}
+}
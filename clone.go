@@ -0,0 +1,163 @@
+package smt
+
+import (
+	"errors"
+	"io"
+)
+
+// cowMapStore wraps a MapStore shared by a tree with its clones. Before a key
+// is written through it, its current value is preserved in the overlay of
+// every clone that has not written the key itself, so that the clones are not
+// affected by the writes.
+type cowMapStore struct {
+	MapStore
+	forks []*overlayMapStore
+}
+
+// preserve copies the current value of a key, or its absence, into the
+// overlays of the clones.
+func (cs *cowMapStore) preserve(key []byte) error {
+	for _, fork := range cs.forks {
+		if _, ok := fork.sets[string(key)]; ok || fork.deletes[string(key)] {
+			continue
+		}
+		value, err := cs.MapStore.Get(key)
+		if err != nil {
+			var invalidKeyError *InvalidKeyError
+			if !errors.As(err, &invalidKeyError) {
+				return err
+			}
+			fork.deletes[string(key)] = true
+			continue
+		}
+		fork.sets[string(key)] = append([]byte(nil), value...)
+	}
+	return nil
+}
+
+// Set updates the value for a key.
+func (cs *cowMapStore) Set(key []byte, value []byte) error {
+	if err := cs.preserve(key); err != nil {
+		return err
+	}
+	return cs.MapStore.Set(key, value)
+}
+
+// Delete deletes a key.
+func (cs *cowMapStore) Delete(key []byte) error {
+	if err := cs.preserve(key); err != nil {
+		return err
+	}
+	return cs.MapStore.Delete(key)
+}
+
+// Close closes the underlying MapStore if it implements io.Closer.
+func (cs *cowMapStore) Close() error {
+	if closer, ok := cs.MapStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// detach stops preserving values in the overlay of a clone.
+func (cs *cowMapStore) detach(fork *overlayMapStore) {
+	for i, f := range cs.forks {
+		if f == fork {
+			cs.forks = append(cs.forks[:i], cs.forks[i+1:]...)
+			return
+		}
+	}
+}
+
+// forkMapStore is a store of a clone: an overlay of a store shared with the
+// tree it was cloned from, which stops receiving copies once closed.
+type forkMapStore struct {
+	*overlayMapStore
+	parent *cowMapStore
+}
+
+// Close detaches the overlay from the store of the tree.
+func (fs *forkMapStore) Close() error {
+	fs.parent.detach(fs.overlayMapStore)
+	return nil
+}
+
+// cowBatch wraps the batch of a tree with clones, preserving the nodes it
+// writes in the clones like cowMapStore.
+type cowBatch struct {
+	BatchWriter
+	nodes *cowMapStore
+}
+
+// Set adds an update of the value for a key to the batch.
+func (cb *cowBatch) Set(key []byte, value []byte) error {
+	if err := cb.nodes.preserve(key); err != nil {
+		return err
+	}
+	return cb.BatchWriter.Set(key, value)
+}
+
+// Delete adds a deletion of a key to the batch.
+func (cb *cowBatch) Delete(key []byte) error {
+	if err := cb.nodes.preserve(key); err != nil {
+		return err
+	}
+	return cb.BatchWriter.Delete(key)
+}
+
+// cow wraps a store of the tree in a cowMapStore, unless it already is one.
+func cow(store MapStore) *cowMapStore {
+	if cs, ok := store.(*cowMapStore); ok {
+		return cs
+	}
+	return &cowMapStore{MapStore: store}
+}
+
+// Clone returns a fork of the tree at its current root, which can be updated
+// independently of the tree. The fork reads the tree's stores, but keeps its
+// own writes in memory; the tree keeps writing to its stores, and copies the
+// previous value of each key it writes into the fork first, so both always
+// see their own state. The copies are kept until the fork is closed, so forks
+// should be closed once they are no longer needed. The fork has no callbacks,
+// batch, root log or write-ahead log.
+func (smt *SparseMerkleTree) Clone() (*SparseMerkleTree, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	nodes := cow(smt.nodes)
+	values := nodes
+	if smt.values != smt.nodes {
+		values = cow(smt.values)
+	}
+	nodesFork := &forkMapStore{overlayMapStore: newOverlayMapStore(nodes.MapStore), parent: nodes}
+	valuesFork := nodesFork
+	if values != nodes {
+		valuesFork = &forkMapStore{overlayMapStore: newOverlayMapStore(values.MapStore), parent: values}
+		values.forks = append(values.forks, valuesFork.overlayMapStore)
+	}
+	nodes.forks = append(nodes.forks, nodesFork.overlayMapStore)
+
+	smt.nodes, smt.values = nodes, values
+	if smt.batch != nil {
+		if _, ok := smt.batch.(*cowBatch); !ok {
+			smt.batch = &cowBatch{BatchWriter: smt.batch, nodes: nodes}
+		}
+	}
+
+	fork := *smt
+	fork.nodes, fork.values = nodesFork, valuesFork
+	fork.orphanCallback = nil
+	fork.persistCallback = nil
+	fork.batch = nil
+	fork.accessLog = nil
+	fork.rootLog = nil
+	fork.wal = nil
+	fork.logger = nil
+	fork.pendingOrphans, fork.newOrphans = nil, nil
+	fork.undoStack = append([]undoEntry(nil), smt.undoStack...)
+	fork.redoStack = append([]undoEntry(nil), smt.redoStack...)
+	if smt.absenceBloom != nil {
+		fork.absenceBloom = smt.absenceBloom.clone()
+	}
+	return &fork, nil
+}
@@ -0,0 +1,158 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	root := smt.Root()
+
+	fork, err := smt.Clone()
+	if err != nil {
+		t.Fatalf("returned error when cloning tree: %v", err)
+	}
+	if !bytes.Equal(fork.Root(), root) {
+		t.Error("clone does not have the root of the tree")
+	}
+
+	// Update the same key to different values in the tree and the clone.
+	smt.Update([]byte("3"), []byte("tree"))
+	smt.Delete([]byte("4"))
+	nodeCount := len(smn.m)
+	fork.Update([]byte("3"), []byte("fork"))
+	fork.Update([]byte("new"), []byte("fork"))
+	if bytes.Equal(smt.Root(), fork.Root()) {
+		t.Error("tree and clone have the same root after different updates")
+	}
+	if len(smn.m) != nodeCount {
+		t.Error("updating clone modified the nodes MapStore")
+	}
+
+	// Each must match a tree built from the same updates.
+	for _, tc := range []struct {
+		tree    *SparseMerkleTree
+		updates map[string]string
+	}{
+		{smt, map[string]string{"3": "tree", "4": ""}},
+		{fork, map[string]string{"3": "fork", "new": "fork"}},
+	} {
+		expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		for i := 0; i < 20; i++ {
+			s := strconv.Itoa(i)
+			expected.Update([]byte(s), []byte(s))
+		}
+		for k, v := range tc.updates {
+			expected.Update([]byte(k), []byte(v))
+		}
+		if !bytes.Equal(tc.tree.Root(), expected.Root()) {
+			t.Error("root after independent updates does not match")
+		}
+		for i := 0; i < 20; i++ {
+			s := strconv.Itoa(i)
+			value, err := tc.tree.Get([]byte(s))
+			if err != nil {
+				t.Errorf("returned error when getting key: %v", err)
+			}
+			expectedValue, _ := expected.Get([]byte(s))
+			if !bytes.Equal(value, expectedValue) {
+				t.Error("got incorrect value after independent updates")
+			}
+			proof, err := tc.tree.Prove([]byte(s))
+			if err != nil {
+				t.Errorf("returned error when proving key: %v", err)
+			}
+			if !VerifyProof(proof, tc.tree.Root(), []byte(s), value, sha256.New()) {
+				t.Error("proof after independent updates failed to verify")
+			}
+		}
+	}
+
+	// Clones of clones are independent too.
+	forkRoot := fork.Root()
+	fork2, _ := fork.Clone()
+	fork2.Update([]byte("3"), []byte("fork2"))
+	fork.Update([]byte("3"), []byte("fork"))
+	if !bytes.Equal(fork.Root(), forkRoot) {
+		t.Error("updating clone of clone modified clone")
+	}
+	value, _ := fork2.Get([]byte("3"))
+	if !bytes.Equal(value, []byte("fork2")) {
+		t.Error("got incorrect value from clone of clone")
+	}
+}
+
+func TestCloneWithBatch(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smn, sha256.New(), WithBatchWriter(NewMapStoreBatch(smn)))
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	root := smt.Root()
+	fork, _ := smt.Clone()
+	for i := 0; i < 20; i++ {
+		smt.Delete([]byte(strconv.Itoa(i)))
+	}
+	if !bytes.Equal(fork.Root(), root) {
+		t.Error("clone root changed")
+	}
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		proof, err := fork.Prove([]byte(s))
+		if err != nil {
+			t.Errorf("returned error when proving key in clone: %v", err)
+		}
+		value, _ := fork.Get([]byte(s))
+		if !VerifyProof(proof, root, []byte(s), value, sha256.New()) || !bytes.Equal(value, []byte(s)) {
+			t.Error("clone was affected by deletions from tree")
+		}
+	}
+}
+
+// Test that a closed clone no longer receives copies of the tree's writes.
+func TestCloneClose(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+
+	var forks []*SparseMerkleTree
+	for i := 0; i < 3; i++ {
+		fork, err := smt.Clone()
+		if err != nil {
+			t.Fatalf("returned error when cloning tree: %v", err)
+		}
+		forks = append(forks, fork)
+	}
+	closed := forks[0].nodes.(*forkMapStore)
+	for _, fork := range forks[:2] {
+		if err := fork.Close(); err != nil {
+			t.Fatalf("returned error when closing clone: %v", err)
+		}
+	}
+
+	nodes, values := smt.nodes.(*cowMapStore), smt.values.(*cowMapStore)
+	if len(nodes.forks) != 1 || len(values.forks) != 1 {
+		t.Fatalf("expected 1 clone attached to the stores, got: %d, %d", len(nodes.forks), len(values.forks))
+	}
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+	if len(closed.sets)+len(closed.deletes) != 0 {
+		t.Error("closed clone received copies of the tree's writes")
+	}
+	if len(nodes.forks[0].sets)+len(nodes.forks[0].deletes) == 0 {
+		t.Error("open clone did not receive copies of the tree's writes")
+	}
+	if value, err := forks[2].Get([]byte("0")); err != nil || len(value) != 0 {
+		t.Errorf("open clone sees the tree's writes: %v", err)
+	}
+}
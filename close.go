@@ -0,0 +1,40 @@
+package smt
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrClosed is returned when using a tree after it has been closed.
+var ErrClosed = errors.New("tree is closed")
+
+// Close commits any pending writes to the batch, closes the nodes and values
+// MapStores if they implement io.Closer, and releases them. The write-ahead
+// log set with WithWAL, if any, is truncated once pending writes are
+// committed. Using the tree after it has been closed returns ErrClosed.
+func (smt *SparseMerkleTree) Close() error {
+	if smt.closed {
+		return ErrClosed
+	}
+	smt.closed = true
+
+	err := smt.commitNodes()
+	if err == nil {
+		err = smt.truncateWAL()
+	}
+	for i, store := range []MapStore{smt.nodes, smt.values} {
+		closer, ok := store.(io.Closer)
+		if !ok {
+			continue
+		}
+		if i == 1 && smt.values == smt.nodes {
+			// Both stores are the same; do not close it twice.
+			break
+		}
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	smt.nodes, smt.values, smt.batch = nil, nil, nil
+	return err
+}
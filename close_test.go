@@ -0,0 +1,44 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// closingMapStore is a SimpleMap that counts calls to Close.
+type closingMapStore struct {
+	*SimpleMap
+	closes int
+}
+
+func (cms *closingMapStore) Close() error {
+	cms.closes++
+	return nil
+}
+
+func TestSparseMerkleTreeClose(t *testing.T) {
+	smn := &closingMapStore{SimpleMap: NewSimpleMap()}
+	smt := NewSparseMerkleTree(smn, smn, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+
+	if err := smt.Close(); err != nil {
+		t.Errorf("returned error when closing tree: %v", err)
+	}
+	if smn.closes != 1 {
+		t.Errorf("expected store to be closed once, got: %d", smn.closes)
+	}
+
+	if _, err := smt.Get([]byte("testKey")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed when getting from closed tree, got: %v", err)
+	}
+	if _, err := smt.Update([]byte("testKey"), []byte("testValue2")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed when updating closed tree, got: %v", err)
+	}
+	if _, err := smt.Prove([]byte("testKey")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed when proving with closed tree, got: %v", err)
+	}
+	if err := smt.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed when closing tree twice, got: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+var leafPrefix = []byte{0}
+var nodePrefix = []byte{1}
+
+// NodeCodec serializes and parses the nodes of a tree. A node is stored in the
+// nodes MapStore as its serialized data, keyed by the digest of that data.
+type NodeCodec interface {
+	// EncodeLeaf serializes a leaf node.
+	EncodeLeaf(path []byte, valueHash []byte) []byte
+	// EncodeInner serializes an inner node from the digests of its children.
+	EncodeInner(leftDigest []byte, rightDigest []byte) []byte
+	// DecodeLeaf parses a leaf node serialized by EncodeLeaf, given the size
+	// of paths.
+	DecodeLeaf(data []byte, pathSize int) (path []byte, valueHash []byte)
+	// DecodeInner parses an inner node serialized by EncodeInner, given the
+	// size of digests.
+	DecodeInner(data []byte, digestSize int) (leftDigest []byte, rightDigest []byte)
+	// IsLeaf returns true if the data is a serialized leaf node.
+	IsLeaf(data []byte) bool
+}
+
+// defaultCodec serializes leaf nodes as a zero byte followed by the path and
+// value hash, and inner nodes as a one byte followed by the digests of the
+// children.
+type defaultCodec struct{}
+
+func (defaultCodec) EncodeLeaf(path []byte, valueHash []byte) []byte {
+	value := make([]byte, 0, len(leafPrefix)+len(path)+len(valueHash))
+	value = append(value, leafPrefix...)
+	value = append(value, path...)
+	value = append(value, valueHash...)
+	return value
+}
+
+func (defaultCodec) EncodeInner(leftDigest []byte, rightDigest []byte) []byte {
+	value := make([]byte, 0, len(nodePrefix)+len(leftDigest)+len(rightDigest))
+	value = append(value, nodePrefix...)
+	value = append(value, leftDigest...)
+	value = append(value, rightDigest...)
+	return value
+}
+
+func (defaultCodec) DecodeLeaf(data []byte, pathSize int) ([]byte, []byte) {
+	return data[len(leafPrefix) : pathSize+len(leafPrefix)], data[len(leafPrefix)+pathSize:]
+}
+
+func (defaultCodec) DecodeInner(data []byte, digestSize int) ([]byte, []byte) {
+	return data[len(nodePrefix) : digestSize+len(nodePrefix)], data[len(nodePrefix)+digestSize:]
+}
+
+func (defaultCodec) IsLeaf(data []byte) bool {
+	return len(data) >= len(leafPrefix) && bytes.Equal(data[:len(leafPrefix)], leafPrefix)
+}
+
+// ErrCodecMismatch is returned by VerifyCodec when a codec does not parse
+// what it serializes.
+var ErrCodecMismatch = errors.New("codec does not round-trip")
+
+// codecRounds is the number of random nodes VerifyCodec tries for each size.
+const codecRounds = 100
+
+// VerifyCodec checks a NodeCodec, e.g. from the test suite of its
+// implementation, by serializing random leaves and inner nodes for common
+// path and digest sizes, and checking that they parse back to the same paths,
+// value hashes and child digests, that IsLeaf classifies them correctly, and
+// that all nodes of a kind have the same size, as the tree expects. The
+// returned error wraps ErrCodecMismatch and describes the first failure.
+func VerifyCodec(codec NodeCodec) error {
+	rng := rand.New(rand.NewSource(1))
+	random := func(size int) []byte {
+		data := make([]byte, size)
+		rng.Read(data)
+		return data
+	}
+
+	for _, sizes := range [][2]int{{32, 32}, {8, 32}, {20, 20}, {64, 64}} {
+		pathSize, digestSize := sizes[0], sizes[1]
+		leafSize := len(codec.EncodeLeaf(make([]byte, pathSize), make([]byte, digestSize)))
+		innerSize := len(codec.EncodeInner(make([]byte, digestSize), make([]byte, digestSize)))
+		for i := 0; i < codecRounds; i++ {
+			path, valueHash := random(pathSize), random(digestSize)
+			data := codec.EncodeLeaf(path, valueHash)
+			if len(data) != leafSize {
+				return fmt.Errorf("%w: leaf of size %d, expected %d", ErrCodecMismatch, len(data), leafSize)
+			}
+			if !codec.IsLeaf(data) {
+				return fmt.Errorf("%w: leaf %x not classified as leaf", ErrCodecMismatch, data)
+			}
+			decodedPath, decodedValueHash := codec.DecodeLeaf(data, pathSize)
+			if !bytes.Equal(decodedPath, path) || !bytes.Equal(decodedValueHash, valueHash) {
+				return fmt.Errorf("%w: leaf %x parsed to path %x and value hash %x", ErrCodecMismatch, data, decodedPath, decodedValueHash)
+			}
+
+			leftDigest, rightDigest := random(digestSize), random(digestSize)
+			data = codec.EncodeInner(leftDigest, rightDigest)
+			if len(data) != innerSize {
+				return fmt.Errorf("%w: inner node of size %d, expected %d", ErrCodecMismatch, len(data), innerSize)
+			}
+			if codec.IsLeaf(data) {
+				return fmt.Errorf("%w: inner node %x classified as leaf", ErrCodecMismatch, data)
+			}
+			decodedLeft, decodedRight := codec.DecodeInner(data, digestSize)
+			if !bytes.Equal(decodedLeft, leftDigest) || !bytes.Equal(decodedRight, rightDigest) {
+				return fmt.Errorf("%w: inner node %x parsed to children %x and %x", ErrCodecMismatch, data, decodedLeft, decodedRight)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// suffixCodec is a codec for tests, which serializes the node type as a
+// trailing byte rather than a leading one.
+type suffixCodec struct{}
+
+func (suffixCodec) EncodeLeaf(path []byte, valueHash []byte) []byte {
+	return append(append(append([]byte{}, path...), valueHash...), 'L')
+}
+
+func (suffixCodec) EncodeInner(leftDigest []byte, rightDigest []byte) []byte {
+	return append(append(append([]byte{}, leftDigest...), rightDigest...), 'I')
+}
+
+func (suffixCodec) DecodeLeaf(data []byte, pathSize int) ([]byte, []byte) {
+	return data[:pathSize], data[pathSize : len(data)-1]
+}
+
+func (suffixCodec) DecodeInner(data []byte, digestSize int) ([]byte, []byte) {
+	return data[:digestSize], data[digestSize : len(data)-1]
+}
+
+func (suffixCodec) IsLeaf(data []byte) bool {
+	return data[len(data)-1] == 'L'
+}
+
+func TestCustomCodec(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	custom := NewSparseMerkleTree(smn, smv, sha256.New(), WithCodec(suffixCodec{}))
+
+	for _, key := range []string{"testKey1", "testKey2", "testKey3", "foo"} {
+		smt.Update([]byte(key), []byte(key))
+		custom.Update([]byte(key), []byte(key))
+	}
+	custom.Delete([]byte("testKey2"))
+	root, _ := smt.Delete([]byte("testKey2"))
+	if bytes.Equal(root, custom.Root()) {
+		t.Error("tree with custom codec has the same root as default tree")
+	}
+	for _, data := range smn.m {
+		if data[len(data)-1] != 'L' && data[len(data)-1] != 'I' {
+			t.Error("node was not serialized with custom codec")
+		}
+	}
+
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), custom.Root(), WithCodec(suffixCodec{}))
+	value, err := imported.GetDescend([]byte("foo"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal([]byte("foo"), value) {
+		t.Error("did not get correct value when getting key")
+	}
+
+	proof, _ := imported.ProveUpdatable([]byte("testKey1"))
+	if !VerifyProof(proof, custom.Root(), []byte("testKey1"), []byte("testKey1"), sha256.New(), WithCodec(suffixCodec{})) {
+		t.Error("valid proof failed to verify")
+	}
+	proof, _ = imported.Prove([]byte("testKey2"))
+	if !VerifyProof(proof, custom.Root(), []byte("testKey2"), defaultValue, sha256.New(), WithCodec(suffixCodec{})) {
+		t.Error("valid non-membership proof failed to verify")
+	}
+	if VerifyProof(proof, custom.Root(), []byte("testKey2"), defaultValue, sha256.New()) {
+		t.Error("proof verified with mismatched codec")
+	}
+}
+
+// swappedCodec is a broken codec for tests, which parses the children of
+// inner nodes in the wrong order.
+type swappedCodec struct {
+	defaultCodec
+}
+
+func (c swappedCodec) DecodeInner(data []byte, digestSize int) ([]byte, []byte) {
+	left, right := c.defaultCodec.DecodeInner(data, digestSize)
+	return right, left
+}
+
+func TestVerifyCodec(t *testing.T) {
+	for _, codec := range []NodeCodec{defaultCodec{}, suffixCodec{}} {
+		if err := VerifyCodec(codec); err != nil {
+			t.Errorf("returned error when verifying valid codec: %v", err)
+		}
+	}
+	if err := VerifyCodec(swappedCodec{}); !errors.Is(err, ErrCodecMismatch) {
+		t.Errorf("expected ErrCodecMismatch when verifying broken codec, got: %v", err)
+	}
+}
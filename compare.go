@@ -0,0 +1,101 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+)
+
+// valueHashForPath descends from the node at the given depth along a path,
+// and returns the value hash of the leaf with that path, or nil if there is no
+// such leaf.
+func (smt *SparseMerkleTree) valueHashForPath(node []byte, depth int, path []byte) ([]byte, error) {
+	for ; depth <= smt.depth(); depth++ {
+		if bytes.Equal(node, smt.th.placeholder()) {
+			return nil, nil
+		}
+		data, err := smt.getNode(node)
+		if err != nil {
+			return nil, err
+		}
+		if smt.th.isLeaf(data) {
+			leafPath, valueHash, err := smt.th.parseLeaf(data)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(leafPath, path) {
+				return nil, nil
+			}
+			return valueHash, nil
+		}
+		if depth == smt.depth() {
+			break
+		}
+		leftNode, rightNode := smt.th.parseNode(data)
+		if getBitAtFromMSB(path, depth) == right {
+			node = rightNode
+		} else {
+			node = leftNode
+		}
+	}
+	return nil, ErrInvalidDepth
+}
+
+// KeyUnchanged returns true if a key has the same value in the trees with
+// roots rootA and rootB, whose nodes are both in the given nodes MapStore. It
+// descends both trees along the key's path at once, and stops as soon as it
+// reaches a subtree common to both trees, so it is much cheaper than
+// comparing the trees in full.
+func KeyUnchanged(nodes MapStore, hasher hash.Hash, rootA []byte, rootB []byte, key []byte, options ...Option) (bool, error) {
+	smt := ImportSparseMerkleTree(nodes, nil, hasher, nil, options...)
+	path := smt.th.path(key)
+	nodeA, nodeB := rootA, rootB
+	if len(nodeA) == 0 {
+		nodeA = smt.th.placeholder()
+	}
+	if len(nodeB) == 0 {
+		nodeB = smt.th.placeholder()
+	}
+
+	depth := 0
+	for ; depth < smt.depth(); depth++ {
+		if bytes.Equal(nodeA, nodeB) {
+			return true, nil
+		}
+		if bytes.Equal(nodeA, smt.th.placeholder()) || bytes.Equal(nodeB, smt.th.placeholder()) {
+			break
+		}
+		dataA, err := smt.getNode(nodeA)
+		if err != nil {
+			return false, err
+		}
+		dataB, err := smt.getNode(nodeB)
+		if err != nil {
+			return false, err
+		}
+		if smt.th.isLeaf(dataA) || smt.th.isLeaf(dataB) {
+			break
+		}
+
+		leftA, rightA := smt.th.parseNode(dataA)
+		leftB, rightB := smt.th.parseNode(dataB)
+		if getBitAtFromMSB(path, depth) == right {
+			nodeA, nodeB = rightA, rightB
+		} else {
+			nodeA, nodeB = leftA, leftB
+		}
+	}
+	if bytes.Equal(nodeA, nodeB) {
+		return true, nil
+	}
+
+	// The trees diverge structurally along the path, so compare the leaves.
+	valueHashA, err := smt.valueHashForPath(nodeA, depth, path)
+	if err != nil {
+		return false, err
+	}
+	valueHashB, err := smt.valueHashForPath(nodeB, depth, path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(valueHashA, valueHashB), nil
+}
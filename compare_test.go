@@ -0,0 +1,62 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestKeyUnchanged(t *testing.T) {
+	nodes := NewSimpleMap()
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	emptyRoot := smt.Root()
+
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	rootA := smt.Root()
+	smt.Update([]byte("3"), []byte("changed"))
+	smt.Update([]byte("new"), []byte("new"))
+	rootB := smt.Root()
+
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		unchanged, err := KeyUnchanged(nodes, sha256.New(), rootA, rootB, []byte(s))
+		if err != nil {
+			t.Errorf("returned error when comparing key: %v", err)
+		}
+		if unchanged != (s != "3") {
+			t.Errorf("unexpected result for key %s: %v", s, unchanged)
+		}
+	}
+
+	unchanged, err := KeyUnchanged(nodes, sha256.New(), rootA, rootB, []byte("new"))
+	if err != nil {
+		t.Errorf("returned error when comparing key: %v", err)
+	}
+	if unchanged {
+		t.Error("added key reported as unchanged")
+	}
+	unchanged, err = KeyUnchanged(nodes, sha256.New(), rootA, rootB, []byte("absent"))
+	if err != nil {
+		t.Errorf("returned error when comparing key: %v", err)
+	}
+	if !unchanged {
+		t.Error("absent key reported as changed")
+	}
+	unchanged, err = KeyUnchanged(nodes, sha256.New(), emptyRoot, rootA, []byte("absent"))
+	if err != nil {
+		t.Errorf("returned error when comparing key: %v", err)
+	}
+	if !unchanged {
+		t.Error("absent key reported as changed against empty root")
+	}
+	unchanged, err = KeyUnchanged(nodes, sha256.New(), emptyRoot, rootA, []byte("1"))
+	if err != nil {
+		t.Errorf("returned error when comparing key: %v", err)
+	}
+	if unchanged {
+		t.Error("added key reported as unchanged against empty root")
+	}
+}
@@ -0,0 +1,295 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// ErrNotConsistent is returned when proving consistency between two roots,
+// and leaves of the old root are missing from the new root.
+var ErrNotConsistent = errors.New("roots are not consistent")
+
+// ConsistencyNode is the digest of an inner node at a position in a tree.
+type ConsistencyNode struct {
+	// Path is a path whose first Depth bits are the position of the node.
+	Path []byte
+	// Depth is the depth of the node, where the root is at depth 0.
+	Depth int
+	// Digest is the digest of the node.
+	Digest []byte
+}
+
+// ConsistencyProof is a proof that a new root was obtained from an old root
+// by only adding keys and modifying the values of keys.
+type ConsistencyProof struct {
+	// SharedNodes are the roots of the subtrees common to both roots.
+	SharedNodes []ConsistencyNode
+	// SharedLeaves is the data of the leaves common to both roots that are
+	// not in a shared subtree.
+	SharedLeaves [][]byte
+	// OldLeaves is the data of the other leaves of the old root.
+	OldLeaves [][]byte
+	// NewLeaves is the data of the leaves of the new root with the paths of
+	// the OldLeaves, and of added leaves.
+	NewLeaves [][]byte
+	// NewNodes are the roots of subtrees of the new root containing only
+	// added leaves.
+	NewNodes []ConsistencyNode
+}
+
+// consistencyProver builds a ConsistencyProof by descending two roots at once.
+type consistencyProver struct {
+	smt   *SparseMerkleTree
+	proof ConsistencyProof
+}
+
+// addNew adds a subtree that only exists in the new root.
+func (cp *consistencyProver) addNew(node []byte, depth int, position []byte) error {
+	if bytes.Equal(node, cp.smt.th.placeholder()) {
+		return nil
+	}
+	data, err := cp.smt.getNode(node)
+	if err != nil {
+		return err
+	}
+	if cp.smt.th.isLeaf(data) {
+		cp.proof.NewLeaves = append(cp.proof.NewLeaves, data)
+		return nil
+	}
+	cp.proof.NewNodes = append(cp.proof.NewNodes, ConsistencyNode{Path: position, Depth: depth, Digest: node})
+	return nil
+}
+
+// childPosition returns the position of a child of the node at the given
+// position and depth.
+func childPosition(position []byte, depth int, bit int) []byte {
+	child := append([]byte(nil), position...)
+	if bit == right {
+		setBitAtFromMSB(child, depth)
+	}
+	return child
+}
+
+func (cp *consistencyProver) walk(oldNode []byte, newNode []byte, depth int, position []byte) error {
+	th := &cp.smt.th
+	if bytes.Equal(oldNode, newNode) {
+		if bytes.Equal(oldNode, th.placeholder()) {
+			return nil
+		}
+		data, err := cp.smt.getNode(oldNode)
+		if err != nil {
+			return err
+		}
+		if th.isLeaf(data) {
+			cp.proof.SharedLeaves = append(cp.proof.SharedLeaves, data)
+		} else {
+			cp.proof.SharedNodes = append(cp.proof.SharedNodes, ConsistencyNode{Path: position, Depth: depth, Digest: oldNode})
+		}
+		return nil
+	}
+	if bytes.Equal(oldNode, th.placeholder()) {
+		return cp.addNew(newNode, depth, position)
+	}
+	if bytes.Equal(newNode, th.placeholder()) || depth >= cp.smt.depth() {
+		return ErrNotConsistent
+	}
+
+	oldData, err := cp.smt.getNode(oldNode)
+	if err != nil {
+		return err
+	}
+	newData, err := cp.smt.getNode(newNode)
+	if err != nil {
+		return err
+	}
+	oldIsLeaf, newIsLeaf := th.isLeaf(oldData), th.isLeaf(newData)
+	switch {
+	case oldIsLeaf && newIsLeaf:
+		// The leaf must have been modified, rather than replaced.
+		oldPath, _, err := th.parseLeaf(oldData)
+		if err != nil {
+			return err
+		}
+		newPath, _, err := th.parseLeaf(newData)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(oldPath, newPath) {
+			return ErrNotConsistent
+		}
+		cp.proof.OldLeaves = append(cp.proof.OldLeaves, oldData)
+		cp.proof.NewLeaves = append(cp.proof.NewLeaves, newData)
+		return nil
+
+	case !oldIsLeaf && newIsLeaf:
+		// Several old leaves were replaced by one.
+		return ErrNotConsistent
+
+	case oldIsLeaf && !newIsLeaf:
+		// Leaves were added next to the old leaf; follow its path down the
+		// new subtree.
+		oldPath, _, err := th.parseLeaf(oldData)
+		if err != nil {
+			return err
+		}
+		leftNode, rightNode := th.parseNode(newData)
+		bit := getBitAtFromMSB(oldPath, depth)
+		onPath, offPath := leftNode, rightNode
+		if bit == right {
+			onPath, offPath = rightNode, leftNode
+		}
+		if err := cp.addNew(offPath, depth+1, childPosition(position, depth, 1-bit)); err != nil {
+			return err
+		}
+		return cp.walk(oldNode, onPath, depth+1, childPosition(position, depth, bit))
+	}
+
+	oldLeft, oldRight := th.parseNode(oldData)
+	newLeft, newRight := th.parseNode(newData)
+	if err := cp.walk(oldLeft, newLeft, depth+1, childPosition(position, depth, 0)); err != nil {
+		return err
+	}
+	return cp.walk(oldRight, newRight, depth+1, childPosition(position, depth, right))
+}
+
+// ProveConsistency generates a proof that newRoot was obtained from oldRoot
+// by only adding keys and modifying the values of keys, which can be verified
+// with VerifyConsistencyProof. The nodes of both roots must be in the nodes
+// MapStore, e.g. with WithNoOrphanTracking. The proof contains the subtrees
+// that are common to both roots, and the leaves where they differ.
+// ErrNotConsistent is returned if keys of oldRoot were deleted.
+func (smt *SparseMerkleTree) ProveConsistency(oldRoot []byte, newRoot []byte) (ConsistencyProof, error) {
+	if len(oldRoot) == 0 {
+		oldRoot = smt.th.placeholder()
+	}
+	if len(newRoot) == 0 {
+		newRoot = smt.th.placeholder()
+	}
+	cp := consistencyProver{smt: smt}
+	if err := cp.walk(oldRoot, newRoot, 0, make([]byte, smt.th.pathSize())); err != nil {
+		return ConsistencyProof{}, err
+	}
+	return cp.proof, nil
+}
+
+// consistencyItem is a leaf or subtree from which a root is rebuilt.
+type consistencyItem struct {
+	path   []byte
+	depth  int
+	digest []byte
+	isLeaf bool
+}
+
+// rebuildRoot computes the root of the subtree at the given depth containing
+// the given leaves and subtrees.
+func rebuildRoot(items []consistencyItem, depth int, th *treeHasher) ([]byte, bool) {
+	if len(items) == 0 {
+		return th.placeholder(), true
+	}
+	if len(items) == 1 && (items[0].isLeaf || items[0].depth == depth) {
+		return items[0].digest, true
+	}
+	if depth >= th.pathSize()*8 {
+		return nil, false
+	}
+	var leftItems, rightItems []consistencyItem
+	for _, item := range items {
+		if !item.isLeaf && item.depth <= depth {
+			// A subtree overlaps other items.
+			return nil, false
+		}
+		if getBitAtFromMSB(item.path, depth) == right {
+			rightItems = append(rightItems, item)
+		} else {
+			leftItems = append(leftItems, item)
+		}
+	}
+	leftNode, ok := rebuildRoot(leftItems, depth+1, th)
+	if !ok {
+		return nil, false
+	}
+	rightNode, ok := rebuildRoot(rightItems, depth+1, th)
+	if !ok {
+		return nil, false
+	}
+	currentHash, _ := th.digestNode(leftNode, rightNode, depth)
+	return currentHash, true
+}
+
+func consistencyLeaves(leaves [][]byte, th *treeHasher) ([]consistencyItem, bool) {
+	items := make([]consistencyItem, 0, len(leaves))
+	for _, data := range leaves {
+		path, _, err := th.parseLeaf(data)
+		if err != nil {
+			return nil, false
+		}
+		items = append(items, consistencyItem{path: path, digest: th.digestData(data), isLeaf: true})
+	}
+	return items, true
+}
+
+func consistencyNodes(nodes []ConsistencyNode, th *treeHasher) ([]consistencyItem, bool) {
+	items := make([]consistencyItem, 0, len(nodes))
+	for _, node := range nodes {
+		if len(node.Path) != th.pathSize() || node.Depth < 0 || node.Depth > th.pathSize()*8 || len(node.Digest) != th.hasher.Size() {
+			return nil, false
+		}
+		items = append(items, consistencyItem{path: node.Path, depth: node.Depth, digest: node.Digest})
+	}
+	return items, true
+}
+
+// VerifyConsistencyProof verifies a proof generated by ProveConsistency, that
+// newRoot was obtained from oldRoot by only adding keys and modifying the
+// values of keys. The options must match those of the tree.
+func VerifyConsistencyProof(proof ConsistencyProof, oldRoot []byte, newRoot []byte, hasher hash.Hash, options ...Option) bool {
+	th := newVerifierTreeHasher(hasher, options)
+	sharedNodes, ok := consistencyNodes(proof.SharedNodes, th)
+	if !ok {
+		return false
+	}
+	newNodes, ok := consistencyNodes(proof.NewNodes, th)
+	if !ok {
+		return false
+	}
+	sharedLeaves, ok := consistencyLeaves(proof.SharedLeaves, th)
+	if !ok {
+		return false
+	}
+	oldLeaves, ok := consistencyLeaves(proof.OldLeaves, th)
+	if !ok {
+		return false
+	}
+	newLeaves, ok := consistencyLeaves(proof.NewLeaves, th)
+	if !ok {
+		return false
+	}
+
+	// Every old leaf must still be in the new root.
+	newPaths := make(map[string]bool, len(newLeaves))
+	for _, item := range newLeaves {
+		newPaths[string(item.path)] = true
+	}
+	for _, item := range oldLeaves {
+		if !newPaths[string(item.path)] {
+			return false
+		}
+	}
+
+	var oldItems, newItems []consistencyItem
+	oldItems = append(append(append(oldItems, sharedNodes...), sharedLeaves...), oldLeaves...)
+	newItems = append(append(append(append(newItems, sharedNodes...), sharedLeaves...), newLeaves...), newNodes...)
+	if len(oldRoot) == 0 {
+		oldRoot = th.placeholder()
+	}
+	if len(newRoot) == 0 {
+		newRoot = th.placeholder()
+	}
+	computedOldRoot, ok := rebuildRoot(oldItems, 0, th)
+	if !ok || !bytes.Equal(computedOldRoot, oldRoot) {
+		return false
+	}
+	computedNewRoot, ok := rebuildRoot(newItems, 0, th)
+	return ok && bytes.Equal(computedNewRoot, newRoot)
+}
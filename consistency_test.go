@@ -0,0 +1,55 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestProveConsistency(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	roots := [][]byte{smt.Root()}
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 5; j++ {
+			s := strconv.Itoa(i*5 + j)
+			smt.Update([]byte(s), []byte(s))
+		}
+		// Also modify an existing key.
+		smt.Update([]byte(strconv.Itoa(i)), []byte("modified"+strconv.Itoa(i)))
+		roots = append(roots, smt.Root())
+	}
+
+	for i := range roots {
+		for j := i; j < len(roots); j++ {
+			proof, err := smt.ProveConsistency(roots[i], roots[j])
+			if err != nil {
+				t.Errorf("returned error when proving consistency: %v", err)
+			}
+			if !VerifyConsistencyProof(proof, roots[i], roots[j], sha256.New()) {
+				t.Errorf("valid consistency proof from root %d to %d failed to verify", i, j)
+			}
+			if i > 0 && i != j && VerifyConsistencyProof(proof, roots[i-1], roots[j], sha256.New()) {
+				t.Error("consistency proof verified against wrong old root")
+			}
+		}
+	}
+
+	// A tree with a deleted key is not consistent with the old root.
+	var kvs []KVPair
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		if i == 3 {
+			continue
+		}
+		value, _ := smt.Get([]byte(s))
+		kvs = append(kvs, KVPair{Key: []byte(s), Value: value})
+	}
+	deleted, _ := BuildSMT(smt.nodes, NewSimpleMap(), sha256.New(), kvs)
+	if _, err := smt.ProveConsistency(roots[5], deleted.Root()); !errors.Is(err, ErrNotConsistent) {
+		t.Errorf("expected ErrNotConsistent when proving consistency after deletion, got: %v", err)
+	}
+	if _, err := smt.ProveConsistency(roots[5], roots[2]); !errors.Is(err, ErrNotConsistent) {
+		t.Errorf("expected ErrNotConsistent when proving consistency backwards, got: %v", err)
+	}
+}
@@ -15,9 +15,9 @@ type DeepSparseMerkleSubTree struct {
 }
 
 // NewDeepSparseMerkleSubTree creates a new deep Sparse Merkle subtree on an empty MapStore.
-func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root []byte) *DeepSparseMerkleSubTree {
+func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) *DeepSparseMerkleSubTree {
 	return &DeepSparseMerkleSubTree{
-		SparseMerkleTree: ImportSparseMerkleTree(nodes, values, hasher, root),
+		SparseMerkleTree: ImportSparseMerkleTree(nodes, values, hasher, root, options...),
 	}
 }
 
@@ -28,12 +28,13 @@ func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root [
 // If the leaf may be updated (e.g. during a state transition fraud proof),
 // an updatable proof should be used. See SparseMerkleTree.ProveUpdatable.
 func (dsmst *DeepSparseMerkleSubTree) AddBranch(proof SparseMerkleProof, key []byte, value []byte) error {
-	result, updates := verifyProofWithUpdates(proof, dsmst.Root(), key, value, dsmst.th.hasher)
+	proof = proof.trimPadding(&dsmst.th, 0)
+	result, updates := verifyProofWithUpdates(proof, dsmst.root, key, value, &dsmst.th)
 	if !result {
 		return ErrBadProof
 	}
 
-	if !bytes.Equal(value, defaultValue) { // Membership proof.
+	if !dsmst.th.isDefault(value) { // Membership proof.
 		if err := dsmst.values.Set(dsmst.th.path(key), value); err != nil {
 			return err
 		}
@@ -41,8 +42,9 @@ func (dsmst *DeepSparseMerkleSubTree) AddBranch(proof SparseMerkleProof, key []b
 
 	// Update nodes along branch
 	for _, update := range updates {
-		err := dsmst.nodes.Set(update[0], update[1])
+		err := dsmst.setNode(update[0], update[1])
 		if err != nil {
+			dsmst.discardNodes()
 			return err
 		}
 	}
@@ -50,13 +52,18 @@ func (dsmst *DeepSparseMerkleSubTree) AddBranch(proof SparseMerkleProof, key []b
 	// Update sibling node
 	if proof.SiblingData != nil {
 		if proof.SideNodes != nil && len(proof.SideNodes) > 0 {
-			err := dsmst.nodes.Set(proof.SideNodes[0], proof.SiblingData)
+			err := dsmst.setNode(proof.SideNodes[0], proof.SiblingData)
 			if err != nil {
+				dsmst.discardNodes()
 				return err
 			}
 		}
 	}
 
+	if err := dsmst.commitNodes(); err != nil {
+		dsmst.discardNodes()
+		return err
+	}
 	return nil
 }
 
@@ -65,25 +72,29 @@ func (dsmst *DeepSparseMerkleSubTree) AddBranch(proof SparseMerkleProof, key []b
 // Errors if the key cannot be reached by descending.
 func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 	// Get tree's root
-	root := smt.Root()
+	root := smt.root
 
 	if bytes.Equal(root, smt.th.placeholder()) {
 		// The tree is empty, return the default value.
-		return defaultValue, nil
+		return smt.th.copyDefaultValue(), nil
 	}
 
 	path := smt.th.path(key)
 	currentHash := root
+	pathNodes := [][]byte{root}
 	for i := 0; i < smt.depth(); i++ {
-		currentData, err := smt.nodes.Get(currentHash)
+		currentData, err := smt.getNode(currentHash)
 		if err != nil {
 			return nil, err
 		} else if smt.th.isLeaf(currentData) {
 			// We've reached the end. Is this the actual leaf?
-			p, _ := smt.th.parseLeaf(currentData)
+			p, _, err := smt.th.parseLeaf(currentData)
+			if err != nil {
+				return nil, err
+			}
 			if !bytes.Equal(path, p) {
 				// Nope. Therefore the key is actually empty.
-				return defaultValue, nil
+				return smt.th.copyDefaultValue(), nil
 			}
 			// Otherwise, yes. Return the value.
 			value, err := smt.values.Get(path)
@@ -102,13 +113,29 @@ func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 
 		if bytes.Equal(currentHash, smt.th.placeholder()) {
 			// We've hit a placeholder value; this is the end.
-			return defaultValue, nil
+			return smt.th.copyDefaultValue(), nil
 		}
+		pathNodes = append(pathNodes, currentHash)
 	}
 
 	// The following lines of code should only be reached if the path is 256
 	// nodes high, which should be very unlikely if the underlying hash function
-	// is collision-resistant.
+	// is collision-resistant. The node at the bottom of the tree must be the
+	// leaf of the key.
+	currentData, err := smt.getNode(currentHash)
+	if err != nil {
+		return nil, err
+	}
+	if !smt.th.isLeaf(currentData) {
+		return nil, innerNodeAtDepthError(pathNodes)
+	}
+	p, _, err := smt.th.parseLeaf(currentData)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(path, p) {
+		return smt.th.copyDefaultValue(), nil
+	}
 	value, err := smt.values.Get(path)
 	if err != nil {
 		return nil, err
@@ -125,5 +152,5 @@ func (smt *SparseMerkleTree) HasDescend(key []byte) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return !bytes.Equal(defaultValue, val), nil
+	return !bytes.Equal(smt.th.defaultValue, val), nil
 }
@@ -0,0 +1,449 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// Magic bytes beginning proofs encoded by MarshalBinary and MarshalProofBatch.
+var (
+	proofMagic        = []byte("SMTP")
+	compactProofMagic = []byte("SMTC")
+	proofBatchMagic   = []byte("SMTB")
+)
+
+// proofFormat is the version of the format of proofs encoded by
+// MarshalBinary and MarshalProofBatch.
+const proofFormat = 1
+
+// writeNullableBytes writes a byte slice preceded by a byte that is 0 if it is
+// nil, and 1 otherwise, so that nil and empty slices are told apart.
+func writeNullableBytes(buf *bytes.Buffer, data []byte) error {
+	if data == nil {
+		return buf.WriteByte(0)
+	}
+	if err := buf.WriteByte(1); err != nil {
+		return err
+	}
+	return writeBytes(buf, data)
+}
+
+// readNullableBytes reads a byte slice written by writeNullableBytes.
+func readNullableBytes(r *bufio.Reader) ([]byte, error) {
+	present, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch present {
+	case 0:
+		return nil, nil
+	case 1:
+		data, err := readBytes(r)
+		if err == nil && data == nil {
+			data = []byte{}
+		}
+		return data, err
+	default:
+		return nil, ErrBadProof
+	}
+}
+
+// readProofHeader reads and checks the magic bytes and version of an encoded
+// proof.
+func readProofHeader(r *bufio.Reader, magic []byte) error {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:len(magic)], magic) || header[len(magic)] != proofFormat {
+		return ErrBadProof
+	}
+	return nil
+}
+
+// MarshalBinary encodes a proof, for sending it over the wire. The encoding is
+// canonical, and stable across versions of this package, so that other
+// implementations can target it. It consists of:
+//
+//	the magic bytes "SMTP"
+//	the format version, 1, as a byte
+//	the number of side nodes, as a uvarint
+//	each side node, as a uvarint length followed by its bytes
+//	NonMembershipLeafData, as a nullable byte slice
+//	SiblingData, as a nullable byte slice
+//
+// A nullable byte slice is a 0 byte if the slice is nil, and otherwise a 1 byte
+// followed by the uvarint length of the slice and its bytes, so that nil and
+// empty slices round-trip exactly.
+func (proof *SparseMerkleProof) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(proofMagic)
+	buf.WriteByte(proofFormat)
+	if err := writeUvarint(&buf, uint64(len(proof.SideNodes))); err != nil {
+		return nil, err
+	}
+	for _, sideNode := range proof.SideNodes {
+		if err := writeBytes(&buf, sideNode); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeNullableBytes(&buf, proof.NonMembershipLeafData); err != nil {
+		return nil, err
+	}
+	if err := writeNullableBytes(&buf, proof.SiblingData); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary. ErrBadProof is
+// returned if the data is not a proof in a known format.
+func (proof *SparseMerkleProof) UnmarshalBinary(data []byte) error {
+	r := bufio.NewReader(bytes.NewReader(data))
+	if err := readProofHeader(r, proofMagic); err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if count > maxProofSideNodes {
+		return ErrBadProof
+	}
+	var decoded SparseMerkleProof
+	for i := uint64(0); i < count; i++ {
+		sideNode, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		decoded.SideNodes = append(decoded.SideNodes, sideNode)
+	}
+	if decoded.NonMembershipLeafData, err = readNullableBytes(r); err != nil {
+		return err
+	}
+	if decoded.SiblingData, err = readNullableBytes(r); err != nil {
+		return err
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		// Trailing data.
+		return ErrBadProof
+	}
+	*proof = decoded
+	return nil
+}
+
+// Fingerprint returns the hash of the encoding of a proof by MarshalBinary, as a
+// key for caching or deduplicating proofs. Since the encoding is canonical,
+// equal proofs have the same fingerprint, however their slices are allocated.
+func (proof SparseMerkleProof) Fingerprint(hasher hash.Hash) []byte {
+	// Encoding to a bytes.Buffer cannot fail.
+	data, _ := proof.MarshalBinary()
+	hasher.Reset()
+	hasher.Write(data)
+	fingerprint := hasher.Sum(nil)
+	hasher.Reset()
+	return fingerprint
+}
+
+// MarshalBinary encodes a compact proof, for sending it over the wire, in a
+// canonical format like that of SparseMerkleProof.MarshalBinary. It consists
+// of:
+//
+//	the magic bytes "SMTC"
+//	the format version, 1, as a byte
+//	NumSideNodes, as a uvarint
+//	BitMask, as a uvarint length followed by its bytes
+//	the number of side nodes, as a uvarint
+//	each side node, as a uvarint length followed by its bytes
+//	NonMembershipLeafData, as a nullable byte slice
+//	SiblingData, as a nullable byte slice
+func (proof *SparseCompactMerkleProof) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(compactProofMagic)
+	buf.WriteByte(proofFormat)
+	if err := writeUvarint(&buf, uint64(proof.NumSideNodes)); err != nil {
+		return nil, err
+	}
+	if err := writeBytes(&buf, proof.BitMask); err != nil {
+		return nil, err
+	}
+	if err := writeUvarint(&buf, uint64(len(proof.SideNodes))); err != nil {
+		return nil, err
+	}
+	for _, sideNode := range proof.SideNodes {
+		if err := writeBytes(&buf, sideNode); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeNullableBytes(&buf, proof.NonMembershipLeafData); err != nil {
+		return nil, err
+	}
+	if err := writeNullableBytes(&buf, proof.SiblingData); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a compact proof encoded by MarshalBinary.
+// ErrBadProof is returned if the data is not a compact proof in a known
+// format.
+func (proof *SparseCompactMerkleProof) UnmarshalBinary(data []byte) error {
+	r := bufio.NewReader(bytes.NewReader(data))
+	if err := readProofHeader(r, compactProofMagic); err != nil {
+		return err
+	}
+	var decoded SparseCompactMerkleProof
+	numSideNodes, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if numSideNodes > maxProofSideNodes {
+		return ErrBadProof
+	}
+	decoded.NumSideNodes = int(numSideNodes)
+	if decoded.BitMask, err = readBytes(r); err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if count > numSideNodes {
+		return ErrBadProof
+	}
+	for i := uint64(0); i < count; i++ {
+		sideNode, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		decoded.SideNodes = append(decoded.SideNodes, sideNode)
+	}
+	if decoded.NonMembershipLeafData, err = readNullableBytes(r); err != nil {
+		return err
+	}
+	if decoded.SiblingData, err = readNullableBytes(r); err != nil {
+		return err
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return ErrBadProof
+	}
+	*proof = decoded
+	return nil
+}
+
+// readOptionalBytes reads a length-prefixed byte slice, which is nil if
+// empty.
+func readOptionalBytes(r *bufio.Reader) ([]byte, error) {
+	data, err := readBytes(r)
+	if len(data) == 0 {
+		data = nil
+	}
+	return data, err
+}
+
+// ProveCompactBytes generates a compacted Merkle proof for a key against the
+// current root, encoded with MarshalBinary, which can be verified with
+// VerifyCompactProofBytes.
+func (smt *SparseMerkleTree) ProveCompactBytes(key []byte) ([]byte, error) {
+	proof, err := smt.ProveCompact(key)
+	if err != nil {
+		return nil, err
+	}
+	return proof.MarshalBinary()
+}
+
+// VerifyCompactProofBytes verifies a compacted Merkle proof encoded with
+// MarshalBinary, as generated by ProveCompactBytes.
+func VerifyCompactProofBytes(data []byte, root []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	var proof SparseCompactMerkleProof
+	if err := proof.UnmarshalBinary(data); err != nil {
+		return false
+	}
+	return VerifyCompactProof(proof, root, key, value, hasher, options...)
+}
+
+// MarshalProofBatch encodes several proofs together, storing each distinct
+// side node once in a dictionary and referring to it by index from the
+// proofs. Side nodes near the root are shared by most proofs of the same tree,
+// and placeholders repeat in every proof, so the encoding is usually much
+// smaller than concatenating the proofs encoded with MarshalBinary. Like
+// MarshalBinary, the encoding is canonical. It consists of:
+//
+//	the magic bytes "SMTB"
+//	the format version, 1, as a byte
+//	the number of side nodes in the dictionary, as a uvarint
+//	each side node, as a uvarint length followed by its bytes
+//	the number of proofs, as a uvarint
+//	for each proof, the number of its side nodes, as a uvarint, the index of
+//	each side node in the dictionary, as a uvarint, and its
+//	NonMembershipLeafData and SiblingData, as nullable byte slices
+func MarshalProofBatch(proofs []SparseMerkleProof) ([]byte, error) {
+	var dictionary [][]byte
+	indices := make(map[string]uint64)
+	for _, proof := range proofs {
+		for _, sideNode := range proof.SideNodes {
+			if _, ok := indices[string(sideNode)]; !ok {
+				indices[string(sideNode)] = uint64(len(dictionary))
+				dictionary = append(dictionary, sideNode)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proofBatchMagic)
+	buf.WriteByte(proofFormat)
+	if err := writeUvarint(&buf, uint64(len(dictionary))); err != nil {
+		return nil, err
+	}
+	for _, sideNode := range dictionary {
+		if err := writeBytes(&buf, sideNode); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeUvarint(&buf, uint64(len(proofs))); err != nil {
+		return nil, err
+	}
+	for _, proof := range proofs {
+		if err := writeUvarint(&buf, uint64(len(proof.SideNodes))); err != nil {
+			return nil, err
+		}
+		for _, sideNode := range proof.SideNodes {
+			if err := writeUvarint(&buf, indices[string(sideNode)]); err != nil {
+				return nil, err
+			}
+		}
+		if err := writeNullableBytes(&buf, proof.NonMembershipLeafData); err != nil {
+			return nil, err
+		}
+		if err := writeNullableBytes(&buf, proof.SiblingData); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalProofBatch decodes proofs encoded by MarshalProofBatch. ErrBadProof
+// is returned if the data is not a batch of proofs in a known format.
+func UnmarshalProofBatch(data []byte) ([]SparseMerkleProof, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	if err := readProofHeader(r, proofBatchMagic); err != nil {
+		return nil, err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// Every entry takes at least one byte, so the counts are not checked
+	// against a bound before reading.
+	var dictionary [][]byte
+	for i := uint64(0); i < count; i++ {
+		sideNode, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		dictionary = append(dictionary, sideNode)
+	}
+
+	count, err = binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	var proofs []SparseMerkleProof
+	for i := uint64(0); i < count; i++ {
+		numSideNodes, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if numSideNodes > maxProofSideNodes {
+			return nil, ErrBadProof
+		}
+		var proof SparseMerkleProof
+		for j := uint64(0); j < numSideNodes; j++ {
+			index, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if index >= uint64(len(dictionary)) {
+				return nil, ErrBadProof
+			}
+			proof.SideNodes = append(proof.SideNodes, dictionary[index])
+		}
+		if proof.NonMembershipLeafData, err = readNullableBytes(r); err != nil {
+			return nil, err
+		}
+		if proof.SiblingData, err = readNullableBytes(r); err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, ErrBadProof
+	}
+	return proofs, nil
+}
+
+// stateFormat is the format of the state encoded by MarshalState.
+const stateFormat = 1
+
+// ErrInvalidState is returned when decoding a corrupt tree state.
+var ErrInvalidState = errors.New("invalid tree state")
+
+// MarshalState encodes the state of the tree that is not kept in its stores:
+// its root and, for a tree with versioned leaves, its version. Since nodes
+// are written to the stores as the tree is updated, the state is all that is
+// needed to resume using the tree with UnmarshalState, e.g. after a process
+// restart. Pending writes to the batch, if any, are committed first.
+func (smt *SparseMerkleTree) MarshalState() ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	if err := smt.commitNodes(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(stateFormat)
+	if err := writeBytes(&buf, smt.root); err != nil {
+		return nil, err
+	}
+	if err := writeUvarint(&buf, smt.version); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalState restores the state encoded by MarshalState to a tree created
+// over the same stores, with the same hasher and options. ErrInvalidState is
+// returned if the state is corrupt, and an error from the nodes MapStore if
+// the root node is not in it.
+func (smt *SparseMerkleTree) UnmarshalState(data []byte) error {
+	if smt.closed {
+		return ErrClosed
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	format, err := r.ReadByte()
+	if err != nil || format != stateFormat {
+		return ErrInvalidState
+	}
+	root, err := readBytes(r)
+	if err != nil || len(root) != smt.th.hasher.Size() {
+		return ErrInvalidState
+	}
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return ErrInvalidState
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return ErrInvalidState
+	}
+	if !bytes.Equal(root, smt.th.placeholder()) {
+		if _, err := smt.getNode(root); err != nil {
+			return err
+		}
+	}
+	smt.root, smt.version = root, version
+	return nil
+}
@@ -0,0 +1,293 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestProofMarshalBinary(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	for _, key := range []string{"3", "absent"} {
+		proof, _ := smt.ProveUpdatable([]byte(key))
+		data, err := proof.MarshalBinary()
+		if err != nil {
+			t.Errorf("returned error when marshaling proof: %v", err)
+		}
+		var decoded SparseMerkleProof
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Errorf("returned error when unmarshaling proof: %v", err)
+		}
+		if !reflect.DeepEqual(proof, decoded) {
+			t.Error("unmarshaled proof does not match")
+		}
+
+		compactProof, _ := smt.ProveCompact([]byte(key))
+		data, err = compactProof.MarshalBinary()
+		if err != nil {
+			t.Errorf("returned error when marshaling compact proof: %v", err)
+		}
+		var decodedCompact SparseCompactMerkleProof
+		if err := decodedCompact.UnmarshalBinary(data); err != nil {
+			t.Errorf("returned error when unmarshaling compact proof: %v", err)
+		}
+		if !reflect.DeepEqual(compactProof, decodedCompact) {
+			t.Error("unmarshaled compact proof does not match")
+		}
+		if decodedCompact.UnmarshalBinary(append(data, 0)) == nil {
+			t.Error("did not return error when unmarshaling proof with trailing data")
+		}
+		if decodedCompact.UnmarshalBinary(data[:len(data)-1]) == nil {
+			t.Error("did not return error when unmarshaling truncated proof")
+		}
+	}
+}
+
+func TestProofMarshalBinaryCanonical(t *testing.T) {
+	proof := SparseMerkleProof{
+		SideNodes:             [][]byte{{1, 2}, {3}},
+		NonMembershipLeafData: []byte{},
+		SiblingData:           nil,
+	}
+	data, err := proof.MarshalBinary()
+	if err != nil {
+		t.Fatalf("returned error when marshaling proof: %v", err)
+	}
+	expected := []byte{'S', 'M', 'T', 'P', 1, 2, 2, 1, 2, 1, 3, 1, 0, 0}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("unexpected encoding: %x", data)
+	}
+	var decoded SparseMerkleProof
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("returned error when unmarshaling proof: %v", err)
+	}
+	if decoded.NonMembershipLeafData == nil || decoded.SiblingData != nil {
+		t.Error("nil and empty fields did not round-trip")
+	}
+	if !reflect.DeepEqual(proof, decoded) {
+		t.Error("unmarshaled proof does not match")
+	}
+
+	compactProof := SparseCompactMerkleProof{
+		SideNodes:             [][]byte{{1}},
+		NonMembershipLeafData: nil,
+		BitMask:               []byte{2},
+		NumSideNodes:          2,
+		SiblingData:           []byte{4},
+	}
+	data, err = compactProof.MarshalBinary()
+	if err != nil {
+		t.Fatalf("returned error when marshaling compact proof: %v", err)
+	}
+	expected = []byte{'S', 'M', 'T', 'C', 1, 2, 1, 2, 1, 1, 1, 0, 1, 1, 4}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("unexpected compact encoding: %x", data)
+	}
+
+	// Proofs in another format, or of the other kind, are rejected.
+	bad := append([]byte(nil), data...)
+	bad[4] = 2
+	var decodedCompact SparseCompactMerkleProof
+	if err := decodedCompact.UnmarshalBinary(bad); err != ErrBadProof {
+		t.Errorf("did not return ErrBadProof for unknown version: %v", err)
+	}
+	if err := decoded.UnmarshalBinary(data); err != ErrBadProof {
+		t.Errorf("did not return ErrBadProof for compact proof: %v", err)
+	}
+}
+
+func TestProofFingerprint(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	proof, _ := smt.Prove([]byte("3"))
+	again, _ := smt.Prove([]byte("3"))
+	// Copy the proof into slices with other capacities.
+	copied := SparseMerkleProof{SideNodes: make([][]byte, 0, 100)}
+	for _, sideNode := range proof.SideNodes {
+		copied.SideNodes = append(copied.SideNodes, append(make([]byte, 0, 64), sideNode...))
+	}
+	fingerprint := proof.Fingerprint(sha256.New())
+	if !bytes.Equal(fingerprint, again.Fingerprint(sha256.New())) {
+		t.Error("fingerprints of equal proofs do not match")
+	}
+	// Proofs that are not addressable, e.g. returned by a function, have a
+	// fingerprint too.
+	prove := func() SparseMerkleProof { return again }
+	if !bytes.Equal(fingerprint, prove().Fingerprint(sha256.New())) {
+		t.Error("fingerprints of equal proofs do not match")
+	}
+	if !bytes.Equal(fingerprint, copied.Fingerprint(sha256.New())) {
+		t.Error("fingerprint depends on the allocation of the proof")
+	}
+
+	other, _ := smt.Prove([]byte("4"))
+	if bytes.Equal(fingerprint, other.Fingerprint(sha256.New())) {
+		t.Error("fingerprints of different proofs match")
+	}
+	// The membership proof has no NonMembershipLeafData.
+	withEmpty := proof
+	withEmpty.NonMembershipLeafData = []byte{}
+	if bytes.Equal(fingerprint, withEmpty.Fingerprint(sha256.New())) {
+		t.Error("fingerprints of proofs with nil and empty fields match")
+	}
+}
+
+func TestProveCompactBytes(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	data, err := smt.ProveCompactBytes([]byte("3"))
+	if err != nil {
+		t.Errorf("returned error when proving key: %v", err)
+	}
+	if !VerifyCompactProofBytes(data, smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+		t.Error("valid proof bytes failed to verify")
+	}
+	if VerifyCompactProofBytes(data, smt.Root(), []byte("3"), []byte("4"), sha256.New()) {
+		t.Error("proof bytes verified with wrong value")
+	}
+	if VerifyCompactProofBytes(data[1:], smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+		t.Error("malformed proof bytes verified")
+	}
+}
+
+func TestProofBatch(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	var proofs []SparseMerkleProof
+	naiveSize := 0
+	for i := 0; i < 100; i += 2 {
+		proof, _ := smt.ProveUpdatable([]byte(strconv.Itoa(i)))
+		proofs = append(proofs, proof)
+		data, _ := proof.MarshalBinary()
+		naiveSize += len(data)
+	}
+	absent, _ := smt.Prove([]byte("absent"))
+	proofs = append(proofs, absent)
+
+	data, err := MarshalProofBatch(proofs)
+	if err != nil {
+		t.Fatalf("returned error when marshaling proof batch: %v", err)
+	}
+	if len(data) >= naiveSize*3/4 {
+		t.Errorf("batch of %d bytes is not much smaller than %d concatenated bytes", len(data), naiveSize)
+	}
+
+	decoded, err := UnmarshalProofBatch(data)
+	if err != nil {
+		t.Fatalf("returned error when unmarshaling proof batch: %v", err)
+	}
+	if !reflect.DeepEqual(proofs, decoded) {
+		t.Error("unmarshaled proof batch does not match")
+	}
+	for i := 0; i < 100; i += 2 {
+		s := strconv.Itoa(i)
+		if !VerifyProof(decoded[i/2], smt.Root(), []byte(s), []byte(s), sha256.New()) {
+			t.Error("unmarshaled proof failed to verify")
+		}
+	}
+	if !VerifyProof(decoded[len(decoded)-1], smt.Root(), []byte("absent"), defaultValue, sha256.New()) {
+		t.Error("unmarshaled non-membership proof failed to verify")
+	}
+
+	if _, err := UnmarshalProofBatch(append(data, 0)); err == nil {
+		t.Error("did not return error when unmarshaling batch with trailing data")
+	}
+	if _, err := UnmarshalProofBatch(data[:len(data)-1]); err == nil {
+		t.Error("did not return error when unmarshaling truncated batch")
+	}
+	if _, err := UnmarshalProofBatch(append([]byte("SMTB\x01"), 0, 1, 1, 0, 0, 0)); err != ErrBadProof {
+		t.Errorf("did not return ErrBadProof when unmarshaling batch with bad index: %v", err)
+	}
+	if _, err := UnmarshalProofBatch(data[4:]); err != ErrBadProof {
+		t.Errorf("did not return ErrBadProof when unmarshaling batch without header: %v", err)
+	}
+
+	// Nil and empty fields round-trip.
+	proofs = []SparseMerkleProof{
+		{SideNodes: [][]byte{}, NonMembershipLeafData: []byte{}, SiblingData: nil},
+		{NonMembershipLeafData: nil, SiblingData: []byte{}},
+	}
+	data, _ = MarshalProofBatch(proofs)
+	decoded, err = UnmarshalProofBatch(data)
+	if err != nil {
+		t.Fatalf("returned error when unmarshaling proof batch: %v", err)
+	}
+	for i, proof := range decoded {
+		if (proof.NonMembershipLeafData == nil) != (proofs[i].NonMembershipLeafData == nil) ||
+			(proof.SiblingData == nil) != (proofs[i].SiblingData == nil) {
+			t.Error("nil and empty fields did not round-trip in batch")
+		}
+	}
+}
+
+func TestMarshalState(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithVersionedLeaves())
+	state, err := smt.MarshalState()
+	if err != nil {
+		t.Fatalf("returned error when marshaling state of empty tree: %v", err)
+	}
+	resumed := NewSparseMerkleTree(smn, smv, sha256.New(), WithVersionedLeaves())
+	if err := resumed.UnmarshalState(state); err != nil {
+		t.Errorf("returned error when unmarshaling state of empty tree: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	state, err = smt.MarshalState()
+	if err != nil {
+		t.Fatalf("returned error when marshaling state: %v", err)
+	}
+	resumed = NewSparseMerkleTree(smn, smv, sha256.New(), WithVersionedLeaves())
+	if err := resumed.UnmarshalState(state); err != nil {
+		t.Fatalf("returned error when unmarshaling state: %v", err)
+	}
+	if !reflect.DeepEqual(resumed.Root(), smt.Root()) || resumed.Version() != smt.Version() {
+		t.Error("resumed tree does not match")
+	}
+	reference := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithVersionedLeaves())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		reference.Update([]byte(s), []byte(s))
+	}
+	reference.Update([]byte("new"), []byte("new"))
+	resumed.Update([]byte("new"), []byte("new"))
+	if !reflect.DeepEqual(resumed.Root(), reference.Root()) {
+		t.Error("resumed tree diverged after update")
+	}
+
+	if resumed.UnmarshalState(append(state, 0)) != ErrInvalidState {
+		t.Error("did not return ErrInvalidState for state with trailing data")
+	}
+	if resumed.UnmarshalState(state[:len(state)-1]) != ErrInvalidState {
+		t.Error("did not return ErrInvalidState for truncated state")
+	}
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithVersionedLeaves())
+	if empty.UnmarshalState(state) == nil {
+		t.Error("did not return error for state whose root is not in the store")
+	}
+	if !reflect.DeepEqual(empty.Root(), empty.th.placeholder()) {
+		t.Error("failed unmarshal modified the tree")
+	}
+}
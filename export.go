@@ -0,0 +1,337 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// exportMagic begins every export written by ExportVerifiable.
+var exportMagic = []byte("SMTX")
+
+// exportVersion is the version of the export format.
+const exportVersion = 1
+
+// ErrInvalidExport is returned when reading an export that is malformed, or
+// whose leaves do not verify against its root.
+var ErrInvalidExport = errors.New("invalid export")
+
+// ExportRecordError is returned by VerifyExportAgainstRoot for a malformed
+// record of an export, with the offset of the record in the export.
+type ExportRecordError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *ExportRecordError) Error() string {
+	return fmt.Sprintf("invalid export record at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *ExportRecordError) Unwrap() error {
+	return e.Err
+}
+
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func writeUvarint(w io.Writer, x uint64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, x)
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+// writeBytes writes a length-prefixed byte slice.
+func writeBytes(w io.Writer, data []byte) error {
+	if err := writeUvarint(w, uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readBytes reads a length-prefixed byte slice. The slice grows as data is
+// read, so a corrupt length cannot cause a large allocation.
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// maxProofSideNodes bounds the number of side nodes of an encoded proof, well
+// above the depth of a tree with any common hash function.
+const maxProofSideNodes = 1 << 16
+
+func writeCompactProof(w io.Writer, proof SparseCompactMerkleProof) error {
+	if err := writeUvarint(w, uint64(proof.NumSideNodes)); err != nil {
+		return err
+	}
+	if err := writeBytes(w, proof.BitMask); err != nil {
+		return err
+	}
+	if err := writeUvarint(w, uint64(len(proof.SideNodes))); err != nil {
+		return err
+	}
+	for _, sideNode := range proof.SideNodes {
+		if err := writeBytes(w, sideNode); err != nil {
+			return err
+		}
+	}
+	return writeBytes(w, proof.NonMembershipLeafData)
+}
+
+func readCompactProof(r *bufio.Reader) (SparseCompactMerkleProof, error) {
+	var proof SparseCompactMerkleProof
+	numSideNodes, err := binary.ReadUvarint(r)
+	if err != nil {
+		return proof, err
+	}
+	if numSideNodes > maxProofSideNodes {
+		return proof, ErrBadProof
+	}
+	proof.NumSideNodes = int(numSideNodes)
+	if proof.BitMask, err = readBytes(r); err != nil {
+		return proof, err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return proof, err
+	}
+	if count > numSideNodes {
+		return proof, ErrBadProof
+	}
+	for i := uint64(0); i < count; i++ {
+		sideNode, err := readBytes(r)
+		if err != nil {
+			return proof, err
+		}
+		proof.SideNodes = append(proof.SideNodes, sideNode)
+	}
+	if proof.NonMembershipLeafData, err = readOptionalBytes(r); err != nil {
+		return proof, err
+	}
+	return proof, nil
+}
+
+// ExportVerifiable writes every leaf of the tree to w, in path order, along
+// with a compacted Merkle proof for it against the root, which is written
+// first. Each leaf can therefore be verified by the reader without trusting
+// the writer, with VerifyExport. Since the tree stores paths rather than
+// keys, leaves are identified by their paths. The tombstones of deleted keys
+// are not exported.
+func (smt *SparseMerkleTree) ExportVerifiable(w io.Writer) error {
+	return smt.ExportVerifiableContext(context.Background(), w)
+}
+
+// ExportVerifiableContext is like ExportVerifiable, but stops and returns the
+// error of the context once it is done, checking it before each leaf. The
+// export written so far is then incomplete, and fails to verify; the tree is
+// not modified.
+func (smt *SparseMerkleTree) ExportVerifiableContext(ctx context.Context, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(exportMagic); err != nil {
+		return err
+	}
+	// The version is followed by a byte of flags, reserved for later use.
+	if _, err := bw.Write([]byte{exportVersion, 0}); err != nil {
+		return err
+	}
+	if err := writeBytes(bw, smt.root); err != nil {
+		return err
+	}
+
+	err := smt.forEachLeaf(smt.root, 0, nil, func(path, valueHash []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if smt.isTombstone(valueHash) {
+			return nil
+		}
+		value, err := smt.values.Get(path)
+		if err != nil {
+			return err
+		}
+		sideNodes, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, smt.root, false)
+		if err != nil {
+			return err
+		}
+		proof, err := smt.proofForBranch(path, sideNodes, pathNodes, leafData, nil)
+		if err != nil {
+			return err
+		}
+		compactedProof, err := compactProof(proof, &smt.th)
+		if err != nil {
+			return err
+		}
+
+		if err := writeBytes(bw, path); err != nil {
+			return err
+		}
+		if err := writeBytes(bw, value); err != nil {
+			return err
+		}
+		return writeCompactProof(bw, compactedProof)
+	})
+	if err != nil {
+		return err
+	}
+
+	// An empty path marks the end of the leaves.
+	if err := writeBytes(bw, nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// VerifyExport reads an export written by ExportVerifiable, and verifies the
+// proof of every leaf against the root of the export. It returns the root and
+// the number of leaves, or ErrInvalidExport if the export does not verify.
+// The options must match those of the exported tree.
+func VerifyExport(r io.Reader, hasher hash.Hash, options ...Option) ([]byte, int, error) {
+	th := newVerifierTreeHasher(hasher, options)
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(exportMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(header[:len(exportMagic)], exportMagic) || header[len(exportMagic)] != exportVersion {
+		return nil, 0, ErrInvalidExport
+	}
+	root, err := readBytes(br)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count := 0
+	var lastPath []byte
+	for {
+		path, err := readBytes(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(path) == 0 {
+			break
+		}
+		if lastPath != nil && bytes.Compare(path, lastPath) <= 0 {
+			// Leaves must be unique and in path order.
+			return nil, 0, ErrInvalidExport
+		}
+		value, err := readBytes(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		compactedProof, err := readCompactProof(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		proof, err := decompactProof(compactedProof, th)
+		if err != nil {
+			return nil, 0, ErrInvalidExport
+		}
+		if th.isDefault(value) {
+			return nil, 0, ErrInvalidExport
+		}
+		if result, _ := verifyProofForPath(proof, root, 0, path, th.digest(value), th); !result {
+			return nil, 0, ErrInvalidExport
+		}
+		lastPath = path
+		count++
+	}
+	return root, count, nil
+}
+
+// VerifyExportAgainstRoot reads an export written by ExportVerifiable, and
+// rebuilds the tree from its leaves in memory, without writing to any store,
+// to check that its root is expectedRoot. Unlike VerifyExport, it does not
+// trust the root written in the export, and does not need the proofs of the
+// leaves. It returns the number of leaves, an ExportRecordError giving the
+// offset of the first malformed record, or ErrInvalidExport if the root does
+// not match. The options must match those of the exported tree. Since
+// tombstones are not exported, the export of a tree with tombstones of
+// deleted keys does not rebuild to the root of the tree.
+func VerifyExportAgainstRoot(r io.Reader, expectedRoot []byte, hasher hash.Hash, options ...Option) (int, error) {
+	options = append(append([]Option(nil), options...), WithEphemeral())
+	smt := NewSparseMerkleTree(nil, nil, hasher, options...)
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	offset := func() int64 {
+		return cr.n - int64(br.Buffered())
+	}
+
+	header := make([]byte, len(exportMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, &ExportRecordError{Offset: 0, Err: err}
+	}
+	if !bytes.Equal(header[:len(exportMagic)], exportMagic) || header[len(exportMagic)] != exportVersion {
+		return 0, &ExportRecordError{Offset: 0, Err: ErrInvalidExport}
+	}
+	if _, err := readBytes(br); err != nil {
+		return 0, &ExportRecordError{Offset: int64(len(header)), Err: err}
+	}
+
+	var leaves []buildLeaf
+	for {
+		recordOffset := offset()
+		path, err := readBytes(br)
+		if err != nil {
+			return 0, &ExportRecordError{Offset: recordOffset, Err: err}
+		}
+		if len(path) == 0 {
+			break
+		}
+		if len(path) != smt.th.pathSize() {
+			return 0, &ExportRecordError{Offset: recordOffset, Err: ErrInvalidPath}
+		}
+		if len(leaves) > 0 && bytes.Compare(path, leaves[len(leaves)-1].path) <= 0 {
+			// Leaves must be unique and in path order.
+			return 0, &ExportRecordError{Offset: recordOffset, Err: ErrInvalidExport}
+		}
+		value, err := readBytes(br)
+		if err != nil {
+			return 0, &ExportRecordError{Offset: recordOffset, Err: err}
+		}
+		if smt.th.isDefault(value) {
+			return 0, &ExportRecordError{Offset: recordOffset, Err: ErrInvalidExport}
+		}
+		if _, err := readCompactProof(br); err != nil {
+			return 0, &ExportRecordError{Offset: recordOffset, Err: err}
+		}
+		leaves = append(leaves, buildLeaf{path: path, value: value})
+	}
+
+	root, err := smt.buildSubtree(nil, leaves, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(expectedRoot) == 0 {
+		expectedRoot = smt.th.placeholder()
+	}
+	if !bytes.Equal(root, expectedRoot) {
+		return 0, ErrInvalidExport
+	}
+	return len(leaves), nil
+}
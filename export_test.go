@@ -0,0 +1,98 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestExportVerifiable(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	var buf bytes.Buffer
+	if err := smt.ExportVerifiable(&buf); err != nil {
+		t.Errorf("returned error when exporting empty tree: %v", err)
+	}
+	root, count, err := VerifyExport(&buf, sha256.New())
+	if err != nil {
+		t.Errorf("returned error when verifying export of empty tree: %v", err)
+	}
+	if !bytes.Equal(root, smt.Root()) || count != 0 {
+		t.Error("unexpected root or count for export of empty tree")
+	}
+
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte("value"+s))
+	}
+	buf.Reset()
+	if err := smt.ExportVerifiable(&buf); err != nil {
+		t.Errorf("returned error when exporting tree: %v", err)
+	}
+	export := buf.Bytes()
+	root, count, err = VerifyExport(bytes.NewReader(export), sha256.New())
+	if err != nil {
+		t.Errorf("returned error when verifying export: %v", err)
+	}
+	if !bytes.Equal(root, smt.Root()) || count != 50 {
+		t.Errorf("unexpected root or count for export, got count: %d", count)
+	}
+
+	// Tamper with a value.
+	tampered := bytes.Replace(export, []byte("value7"), []byte("value8"), 1)
+	if _, _, err := VerifyExport(bytes.NewReader(tampered), sha256.New()); !errors.Is(err, ErrInvalidExport) {
+		t.Errorf("expected ErrInvalidExport when verifying tampered export, got: %v", err)
+	}
+
+	// Truncate the export.
+	if _, _, err := VerifyExport(bytes.NewReader(export[:len(export)-1]), sha256.New()); err == nil {
+		t.Error("did not return error when verifying truncated export")
+	}
+}
+
+func TestVerifyExportAgainstRoot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var buf bytes.Buffer
+	smt.ExportVerifiable(&buf)
+	count, err := VerifyExportAgainstRoot(&buf, smt.Root(), sha256.New())
+	if err != nil || count != 0 {
+		t.Errorf("unexpected result when verifying export of empty tree: %d, %v", count, err)
+	}
+
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte("value"+s))
+	}
+	buf.Reset()
+	smt.ExportVerifiable(&buf)
+	export := buf.Bytes()
+	count, err = VerifyExportAgainstRoot(bytes.NewReader(export), smt.Root(), sha256.New())
+	if err != nil {
+		t.Errorf("returned error when verifying export: %v", err)
+	}
+	if count != 50 {
+		t.Errorf("expected 50 leaves, got %d", count)
+	}
+
+	// The export does not match another root.
+	if _, err := VerifyExportAgainstRoot(bytes.NewReader(export), smt.th.placeholder(), sha256.New()); err != ErrInvalidExport {
+		t.Errorf("did not return ErrInvalidExport for wrong root: %v", err)
+	}
+
+	// A truncated export is reported at the offset of the truncated record.
+	_, err = VerifyExportAgainstRoot(bytes.NewReader(export[:len(export)-10]), smt.Root(), sha256.New())
+	var recordError *ExportRecordError
+	if !errors.As(err, &recordError) {
+		t.Fatalf("did not return ExportRecordError for truncated export: %v", err)
+	}
+	if recordError.Offset <= 0 || recordError.Offset >= int64(len(export)-10) {
+		t.Errorf("unexpected offset of truncated record: %d", recordError.Offset)
+	}
+	// The record at the offset is the last one.
+	last, _, _ := smt.IterateFrom(nil, 0)
+	if !bytes.Equal(export[recordError.Offset+1:recordError.Offset+1+sha256.Size], last[len(last)-1].Path) {
+		t.Error("offset is not that of the truncated record")
+	}
+}
@@ -0,0 +1,76 @@
+package smt
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+)
+
+// InvalidRecordError is returned when a node record cannot be imported.
+type InvalidRecordError struct {
+	Digest []byte
+	Reason string
+}
+
+func (e *InvalidRecordError) Error() string {
+	return fmt.Sprintf("invalid node record %x: %s", e.Digest, e.Reason)
+}
+
+// ImportNodes imports a Sparse Merkle tree from a set of node records, mapping
+// node digests to serialized node data, such as those captured in a snapshot.
+// Each record is validated to hash to its digest, and every node reachable
+// from the root must be present, before any record is written to the nodes
+// MapStore. Values are not part of node records and must be restored to the
+// values MapStore separately.
+func ImportNodes(nodes, values MapStore, records map[string][]byte, root []byte, hasher hash.Hash, options ...Option) (*SparseMerkleTree, error) {
+	smt := ImportSparseMerkleTree(nodes, values, hasher, root, options...)
+	th := &smt.th
+
+	for digest, data := range records {
+		var expectedSize int
+		switch {
+		case len(data) == 0:
+			return nil, &InvalidRecordError{Digest: []byte(digest), Reason: "empty node data"}
+		case th.isLeaf(data):
+			expectedSize = th.leafSize()
+		default:
+			expectedSize = th.nodeSize()
+		}
+		if len(data) != expectedSize {
+			return nil, &InvalidRecordError{Digest: []byte(digest), Reason: "unexpected node data size"}
+		}
+		if !bytes.Equal(th.digestData(data), []byte(digest)) {
+			return nil, &InvalidRecordError{Digest: []byte(digest), Reason: "node data does not match digest"}
+		}
+	}
+
+	// Check that every node reachable from the root has a record.
+	pending := [][]byte{root}
+	for len(pending) > 0 {
+		digest := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if bytes.Equal(digest, th.placeholder()) {
+			continue
+		}
+		data, ok := records[string(digest)]
+		if !ok {
+			return nil, &InvalidRecordError{Digest: digest, Reason: "missing node record"}
+		}
+		if !th.isLeaf(data) {
+			leftNode, rightNode := th.parseNode(data)
+			pending = append(pending, leftNode, rightNode)
+		}
+	}
+
+	for digest, data := range records {
+		if err := smt.setNode([]byte(digest), data); err != nil {
+			smt.discardNodes()
+			return nil, err
+		}
+	}
+	if err := smt.commitNodes(); err != nil {
+		smt.discardNodes()
+		return nil, err
+	}
+	return smt, nil
+}
@@ -0,0 +1,95 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"testing"
+)
+
+func TestImportNodes(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	root, _ := smt.Update([]byte("foo"), []byte("testValue3"))
+
+	records := func() map[string][]byte {
+		records := make(map[string][]byte)
+		for digest, data := range smn.m {
+			records[digest] = data
+		}
+		return records
+	}
+
+	imported, err := ImportNodes(NewSimpleMap(), smv, records(), root, sha256.New())
+	if err != nil {
+		t.Fatalf("returned error when importing node records: %v", err)
+	}
+	value, err := imported.GetDescend([]byte("foo"))
+	if err != nil {
+		t.Errorf("returned error when getting imported key: %v", err)
+	}
+	if !bytes.Equal([]byte("testValue3"), value) {
+		t.Error("did not get correct value when getting imported key")
+	}
+	proof, _ := imported.Prove([]byte("testKey1"))
+	if !VerifyProof(proof, root, []byte("testKey1"), []byte("testValue1"), sha256.New()) {
+		t.Error("valid proof from imported tree failed to verify")
+	}
+
+	// Case: node data does not match its digest.
+	corrupted := records()
+	for digest, data := range corrupted {
+		data = append([]byte{}, data...)
+		data[len(data)-1] ^= 1
+		corrupted[digest] = data
+		break
+	}
+	_, err = ImportNodes(NewSimpleMap(), NewSimpleMap(), corrupted, root, sha256.New())
+	var recordErr *InvalidRecordError
+	if !errors.As(err, &recordErr) {
+		t.Errorf("did not return InvalidRecordError for corrupted record: %v", err)
+	}
+
+	// Case: a node reachable from the root is missing.
+	missing := records()
+	for digest, data := range missing {
+		if !bytes.Equal([]byte(digest), root) && smt.th.isLeaf(data) {
+			delete(missing, digest)
+			break
+		}
+	}
+	nodes := NewSimpleMap()
+	_, err = ImportNodes(nodes, NewSimpleMap(), missing, root, sha256.New())
+	if !errors.As(err, &recordErr) {
+		t.Errorf("did not return InvalidRecordError for missing record: %v", err)
+	}
+	if len(nodes.m) != 0 {
+		t.Error("wrote node records despite failed validation")
+	}
+}
+
+func TestImportHasherMismatch(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha512.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), smt.Root())
+	if _, err := imported.Get([]byte("testKey")); err != ErrHasherMismatch {
+		t.Errorf("did not return ErrHasherMismatch when getting key: %v", err)
+	}
+	if _, err := imported.Prove([]byte("testKey")); err != ErrHasherMismatch {
+		t.Errorf("did not return ErrHasherMismatch when proving key: %v", err)
+	}
+	if _, err := imported.Update([]byte("testKey2"), []byte("testValue2")); err != ErrHasherMismatch {
+		t.Errorf("did not return ErrHasherMismatch when updating key: %v", err)
+	}
+
+	// The placeholder of another hasher is not an empty tree either.
+	imported = ImportSparseMerkleTree(smn, smv, sha256.New(), make([]byte, sha512.Size))
+	if _, err := imported.Prove([]byte("testKey")); err != ErrHasherMismatch {
+		t.Errorf("did not return ErrHasherMismatch when proving key in empty tree: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrValueCorrupt is returned when a value read from the values MapStore does
+// not hash to the value hash of its leaf.
+var ErrValueCorrupt = errors.New("value does not match value hash")
+
+// RehashNode recomputes the digest of the node stored under digest from its
+// serialized data, and returns it. If rewrite is true and the recomputed
+// digest differs, e.g. due to corruption of the nodes MapStore, the node data
+// is also written under the recomputed digest; the record under the original
+// digest is left in place.
+func (smt *SparseMerkleTree) RehashNode(digest []byte, rewrite bool) ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	data, err := smt.nodes.Get(digest)
+	if err != nil {
+		return nil, err
+	}
+	rehashed := smt.th.digestData(data)
+	if rewrite && !bytes.Equal(rehashed, digest) {
+		err := smt.setNode(rehashed, data)
+		if err == nil {
+			err = smt.commitNodes()
+		}
+		if err != nil {
+			smt.discardNodes()
+			return nil, err
+		}
+	}
+	return rehashed, nil
+}
+
+// StoreInconsistencyError is returned by ValidateAgainstStore for the first
+// node of the tree that is inconsistent with the stores.
+type StoreInconsistencyError struct {
+	Digest []byte
+	Err    error
+}
+
+func (e *StoreInconsistencyError) Error() string {
+	return fmt.Sprintf("inconsistent node %x: %v", e.Digest, e.Err)
+}
+
+func (e *StoreInconsistencyError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateAgainstStore checks that every node reachable from the root of the
+// tree is in the nodes MapStore, hashes to its digest, and parses as a leaf or
+// an inner node, and that the value of every leaf is in the values MapStore
+// and hashes to the value hash of the leaf. It is a diagnostic for stores that
+// were partially written, e.g. after a crash, and returns a
+// StoreInconsistencyError for the first node that fails a check, in
+// depth-first, left-to-right order.
+func (smt *SparseMerkleTree) ValidateAgainstStore() error {
+	return smt.ValidateAgainstStoreContext(context.Background())
+}
+
+// ValidateAgainstStoreContext is like ValidateAgainstStore, but stops and
+// returns the error of the context once it is done, checking it before each
+// node. Neither the tree nor its stores are modified by validating.
+func (smt *SparseMerkleTree) ValidateAgainstStoreContext(ctx context.Context) error {
+	if smt.closed {
+		return ErrClosed
+	}
+	return smt.validateNode(ctx, smt.root, 0)
+}
+
+func (smt *SparseMerkleTree) validateNode(ctx context.Context, digest []byte, depth int) error {
+	if bytes.Equal(digest, smt.th.placeholder()) {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := smt.nodes.Get(digest)
+	if err != nil {
+		return &StoreInconsistencyError{Digest: digest, Err: err}
+	}
+	if err := smt.th.checkFormatVersion(data); err != nil {
+		return &StoreInconsistencyError{Digest: digest, Err: err}
+	}
+	if !bytes.Equal(smt.th.digestData(data), digest) {
+		return &StoreInconsistencyError{Digest: digest, Err: ErrNodeCorrupt}
+	}
+
+	if smt.th.isLeaf(data) {
+		path, valueHash, err := smt.th.parseLeaf(data)
+		if err != nil {
+			return &StoreInconsistencyError{Digest: digest, Err: err}
+		}
+		if smt.isTombstone(valueHash) {
+			return nil
+		}
+		value, err := smt.values.Get(path)
+		if err != nil {
+			return &StoreInconsistencyError{Digest: digest, Err: err}
+		}
+		if !bytes.Equal(smt.th.digest(value), valueHash) {
+			return &StoreInconsistencyError{Digest: digest, Err: ErrValueCorrupt}
+		}
+		return nil
+	}
+
+	if len(data) != smt.th.nodeSize() {
+		return &StoreInconsistencyError{Digest: digest, Err: ErrNodeCorrupt}
+	}
+	if depth >= smt.depth() {
+		return &StoreInconsistencyError{Digest: digest, Err: ErrCycleDetected}
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.validateNode(ctx, leftNode, depth+1); err != nil {
+		return err
+	}
+	return smt.validateNode(ctx, rightNode, depth+1)
+}
@@ -0,0 +1,106 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestRehashNode(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	root, _ := smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	rehashed, err := smt.RehashNode(root, true)
+	if err != nil {
+		t.Errorf("returned error when rehashing node: %v", err)
+	}
+	if !bytes.Equal(rehashed, root) {
+		t.Error("rehashed digest of intact node differs")
+	}
+	if len(smn.m) != 3 {
+		t.Error("rehashing intact node modified the nodes MapStore")
+	}
+
+	// Corrupt the root node by swapping its children.
+	leftNode, rightNode := smt.th.parseNode(smn.m[string(root)])
+	_, corrupted := smt.th.digestNode(rightNode, leftNode, 0)
+	smn.m[string(root)] = corrupted
+	rehashed, err = smt.RehashNode(root, false)
+	if err != nil {
+		t.Errorf("returned error when rehashing node: %v", err)
+	}
+	if bytes.Equal(rehashed, root) {
+		t.Error("rehashed digest of corrupted node is unchanged")
+	}
+	if _, ok := smn.m[string(rehashed)]; ok {
+		t.Error("corrupted node was written under rehashed digest without rewrite")
+	}
+	again, err := smt.RehashNode(root, true)
+	if err != nil {
+		t.Errorf("returned error when rehashing node: %v", err)
+	}
+	if !bytes.Equal(again, rehashed) || !bytes.Equal(smn.m[string(rehashed)], corrupted) {
+		t.Error("corrupted node was not written under rehashed digest")
+	}
+
+	if _, err := smt.RehashNode(smt.th.placeholder(), false); err == nil {
+		t.Error("did not return an error when rehashing a missing node")
+	}
+
+	smt.Close()
+	if _, err := smt.RehashNode(root, false); err != ErrClosed {
+		t.Errorf("did not return ErrClosed when rehashing node of closed tree: %v", err)
+	}
+}
+
+func TestValidateAgainstStore(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	if err := smt.ValidateAgainstStore(); err != nil {
+		t.Errorf("returned error when validating empty tree: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte{byte(i)}, []byte{byte(i)})
+	}
+	if err := smt.ValidateAgainstStore(); err != nil {
+		t.Errorf("returned error when validating intact tree: %v", err)
+	}
+
+	// Remove a leaf's value, as if the values MapStore was partially written.
+	path := smt.th.path([]byte{3})
+	value := smv.m[string(path)]
+	delete(smv.m, string(path))
+	var inconsistency *StoreInconsistencyError
+	err := smt.ValidateAgainstStore()
+	if !errors.As(err, &inconsistency) {
+		t.Fatalf("did not return StoreInconsistencyError for missing value: %v", err)
+	}
+	leafHash, _ := smt.th.digestLeaf(path, smt.th.digest(value))
+	if !bytes.Equal(inconsistency.Digest, leafHash) {
+		t.Error("did not report digest of leaf with missing value")
+	}
+	smv.m[string(path)] = []byte("corrupted")
+	if err := smt.ValidateAgainstStore(); !errors.Is(err, ErrValueCorrupt) {
+		t.Errorf("did not return ErrValueCorrupt for corrupted value: %v", err)
+	}
+	smv.m[string(path)] = value
+
+	// Remove the root node.
+	rootData := smn.m[string(smt.Root())]
+	delete(smn.m, string(smt.Root()))
+	err = smt.ValidateAgainstStore()
+	if !errors.As(err, &inconsistency) || !bytes.Equal(inconsistency.Digest, smt.Root()) {
+		t.Errorf("did not report missing root node: %v", err)
+	}
+
+	// Corrupt the root node by swapping its children.
+	leftNode, rightNode := smt.th.parseNode(rootData)
+	_, corrupted := smt.th.digestNode(rightNode, leftNode, 0)
+	smn.m[string(smt.Root())] = corrupted
+	if err := smt.ValidateAgainstStore(); !errors.Is(err, ErrNodeCorrupt) {
+		t.Errorf("did not return ErrNodeCorrupt for corrupted node: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// errStopIteration is returned by iteration callbacks to end an iteration
+// early.
+var errStopIteration = errors.New("stop iteration")
+
+// LeafEntry is a leaf of a tree. Since the tree stores paths rather than
+// keys, a leaf is identified by its path.
+type LeafEntry struct {
+	Path  []byte
+	Value []byte
+}
+
+// leafValue returns the value of a leaf of the tree, as returned by Get,
+// without the version of a tree with versioned leaves.
+func (smt *SparseMerkleTree) leafValue(path []byte) ([]byte, error) {
+	value, err := smt.values.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if smt.versionedLeaves {
+		if value, _, err = splitVersion(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// forEachLeaf calls fn for each leaf in the subtree rooted at root, which is
+// at the given depth, in path order, skipping leaves with paths before start.
+// If start is nil, no leaves are skipped.
+func (smt *SparseMerkleTree) forEachLeaf(root []byte, depth int, start []byte, fn func(path, valueHash []byte) error) error {
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return nil
+	}
+	data, err := smt.getNode(root)
+	if err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		path, valueHash, err := smt.th.parseLeaf(data)
+		if err != nil {
+			return err
+		}
+		if start != nil && bytes.Compare(path, start) < 0 {
+			return nil
+		}
+		return fn(path, valueHash)
+	}
+	if depth >= smt.depth() {
+		return ErrCycleDetected
+	}
+
+	leftNode, rightNode := smt.th.parseNode(data)
+	if start == nil {
+		if err := smt.forEachLeaf(leftNode, depth+1, nil, fn); err != nil {
+			return err
+		}
+		return smt.forEachLeaf(rightNode, depth+1, nil, fn)
+	}
+	// The subtree is on the boundary of start: every path in it has the same
+	// first depth bits as start.
+	if getBitAtFromMSB(start, depth) == right {
+		return smt.forEachLeaf(rightNode, depth+1, start, fn)
+	}
+	if err := smt.forEachLeaf(leftNode, depth+1, start, fn); err != nil {
+		return err
+	}
+	return smt.forEachLeaf(rightNode, depth+1, nil, fn)
+}
+
+// IterateFrom returns up to limit leaves of the tree, in path order, starting
+// from the first leaf whose path is greater than or equal to start. If start
+// is nil, iteration starts from the first leaf; if limit is not positive, all
+// remaining leaves are returned. The tombstones of deleted keys are skipped.
+//
+// It also returns a cursor, which is the path of the leaf following the last
+// one returned, to be passed as start to continue the iteration, or nil if
+// there are no more leaves. The cursor is stable as long as the tree is not
+// modified.
+func (smt *SparseMerkleTree) IterateFrom(start []byte, limit int) ([]LeafEntry, []byte, error) {
+	return smt.IterateFromContext(context.Background(), start, limit)
+}
+
+// IterateFromContext is like IterateFrom, but stops and returns the error of
+// the context once it is done, checking it before each leaf. The tree is not
+// modified by iterating, so it remains usable after cancellation.
+func (smt *SparseMerkleTree) IterateFromContext(ctx context.Context, start []byte, limit int) ([]LeafEntry, []byte, error) {
+	if start != nil && len(start) != smt.th.pathSize() {
+		return nil, nil, ErrInvalidPath
+	}
+
+	var leaves []LeafEntry
+	var cursor []byte
+	err := smt.forEachLeaf(smt.root, 0, start, func(path, valueHash []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if smt.isTombstone(valueHash) {
+			return nil
+		}
+		if limit > 0 && len(leaves) == limit {
+			cursor = path
+			return errStopIteration
+		}
+		value, err := smt.leafValue(path)
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, LeafEntry{Path: path, Value: value})
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, nil, err
+	}
+	return leaves, cursor, nil
+}
@@ -0,0 +1,142 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestIterateFrom(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	leaves, cursor, err := smt.IterateFrom(nil, 10)
+	if err != nil {
+		t.Errorf("returned error when iterating empty tree: %v", err)
+	}
+	if len(leaves) != 0 || cursor != nil {
+		t.Error("iterating empty tree returned leaves")
+	}
+
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	all, cursor, err := smt.IterateFrom(nil, 0)
+	if err != nil {
+		t.Errorf("returned error when iterating tree: %v", err)
+	}
+	if len(all) != 50 || cursor != nil {
+		t.Fatalf("expected 50 leaves and no cursor, got: %d", len(all))
+	}
+	for i, leaf := range all {
+		if i > 0 && bytes.Compare(all[i-1].Path, leaf.Path) >= 0 {
+			t.Error("leaves are not in path order")
+		}
+		value, _ := smt.values.Get(leaf.Path)
+		if !bytes.Equal(value, leaf.Value) {
+			t.Error("leaf value is not as expected")
+		}
+	}
+
+	// Paginate through the tree.
+	var paged []LeafEntry
+	cursor = nil
+	for {
+		var page []LeafEntry
+		page, cursor, err = smt.IterateFrom(cursor, 7)
+		if err != nil {
+			t.Fatalf("returned error when iterating tree: %v", err)
+		}
+		if len(page) > 7 {
+			t.Errorf("expected at most 7 leaves, got: %d", len(page))
+		}
+		paged = append(paged, page...)
+		if cursor == nil {
+			break
+		}
+		if !bytes.Equal(cursor, all[len(paged)].Path) {
+			t.Error("cursor is not the path of the next leaf")
+		}
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("expected %d paged leaves, got: %d", len(all), len(paged))
+	}
+	for i := range all {
+		if !bytes.Equal(all[i].Path, paged[i].Path) {
+			t.Error("paged leaves do not match all leaves")
+		}
+	}
+
+	// Start between two leaves.
+	start := append([]byte{}, all[20].Path...)
+	start[len(start)-1]++
+	leaves, _, err = smt.IterateFrom(start, 1)
+	if err != nil {
+		t.Errorf("returned error when iterating tree: %v", err)
+	}
+	if len(leaves) != 1 || !bytes.Equal(leaves[0].Path, all[21].Path) {
+		t.Error("iteration did not start from the first leaf after start")
+	}
+
+	if _, _, err := smt.IterateFrom([]byte{0}, 1); err != ErrInvalidPath {
+		t.Errorf("did not return ErrInvalidPath for start of wrong size: %v", err)
+	}
+}
+
+// cancelingMapStore is a MapStore for tests that cancels a context after a
+// number of calls to Get.
+type cancelingMapStore struct {
+	MapStore
+	gets   int
+	cancel context.CancelFunc
+}
+
+func (s *cancelingMapStore) Get(key []byte) ([]byte, error) {
+	s.gets--
+	if s.gets == 0 {
+		s.cancel()
+	}
+	return s.MapStore.Get(key)
+}
+
+func TestContextCancellation(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	root := smt.Root()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := smt.IterateFromContext(ctx, nil, 0); err != context.Canceled {
+		t.Errorf("expected context.Canceled when iterating, got: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := smt.ExportVerifiableContext(ctx, &buf); err != context.Canceled {
+		t.Errorf("expected context.Canceled when exporting, got: %v", err)
+	}
+	if err := smt.ValidateAgainstStoreContext(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled when validating, got: %v", err)
+	}
+
+	// Cancel in the middle of an iteration.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	canceling := &cancelingMapStore{MapStore: smn, gets: 20, cancel: cancel}
+	tree := ImportSparseMerkleTree(canceling, smt.values, sha256.New(), root)
+	if _, _, err := tree.IterateFromContext(ctx, nil, 0); err != context.Canceled {
+		t.Errorf("expected context.Canceled when iterating, got: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("canceled iteration modified the tree")
+	}
+	leaves, _, err := smt.IterateFromContext(context.Background(), nil, 0)
+	if err != nil || len(leaves) != 50 {
+		t.Error("did not iterate over all leaves with a live context")
+	}
+}
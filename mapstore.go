@@ -2,6 +2,7 @@ package smt
 
 import (
 	"fmt"
+	"sync"
 )
 
 // MapStore is a key-value store.
@@ -55,3 +56,145 @@ func (sm *SimpleMap) Delete(key []byte) error {
 	}
 	return &InvalidKeyError{Key: key}
 }
+
+// ConcurrentMapStore is implemented by MapStores that are safe for concurrent
+// use, as required of the stores of a tree built with WithParallelSave.
+type ConcurrentMapStore interface {
+	MapStore
+	// ConcurrentSafe declares that the store is safe for concurrent use.
+	ConcurrentSafe()
+}
+
+// syncMapStore is a MapStore guarded by a mutex.
+type syncMapStore struct {
+	mu      sync.Mutex
+	backing MapStore
+}
+
+// NewSyncMapStore returns a MapStore that serializes the operations on a
+// backing MapStore with a mutex, so that it is safe for concurrent use.
+func NewSyncMapStore(backing MapStore) ConcurrentMapStore {
+	return &syncMapStore{backing: backing}
+}
+
+func (sms *syncMapStore) Get(key []byte) ([]byte, error) {
+	sms.mu.Lock()
+	defer sms.mu.Unlock()
+	return sms.backing.Get(key)
+}
+
+func (sms *syncMapStore) Set(key []byte, value []byte) error {
+	sms.mu.Lock()
+	defer sms.mu.Unlock()
+	return sms.backing.Set(key, value)
+}
+
+func (sms *syncMapStore) Delete(key []byte) error {
+	sms.mu.Lock()
+	defer sms.mu.Unlock()
+	return sms.backing.Delete(key)
+}
+
+func (sms *syncMapStore) ConcurrentSafe() {}
+
+// shardedMapStore is a MapStore that spreads keys over several MapStores by
+// their first byte. Keys of the nodes and values MapStores are digests, so
+// they are spread evenly.
+type shardedMapStore struct {
+	shards []MapStore
+}
+
+// NewShardedMapStore creates a MapStore that dispatches each key to
+// shards[key[0] % len(shards)]. Empty keys go to the first shard. shards must
+// not be empty, and must not be reordered between uses.
+func NewShardedMapStore(shards []MapStore) MapStore {
+	return &shardedMapStore{
+		shards: shards,
+	}
+}
+
+func (sms *shardedMapStore) shard(key []byte) MapStore {
+	if len(key) == 0 {
+		return sms.shards[0]
+	}
+	return sms.shards[int(key[0])%len(sms.shards)]
+}
+
+// Get gets the value for a key.
+func (sms *shardedMapStore) Get(key []byte) ([]byte, error) {
+	return sms.shard(key).Get(key)
+}
+
+// Set updates the value for a key.
+func (sms *shardedMapStore) Set(key []byte, value []byte) error {
+	return sms.shard(key).Set(key, value)
+}
+
+// Delete deletes a key.
+func (sms *shardedMapStore) Delete(key []byte) error {
+	return sms.shard(key).Delete(key)
+}
+
+// AccessOp is the kind of an Access to a MapStore.
+type AccessOp int
+
+// Kinds of Access to a MapStore.
+const (
+	AccessGet AccessOp = iota
+	AccessSet
+	AccessDelete
+)
+
+// Access is an access to a MapStore recorded by a TracingMapStore.
+type Access struct {
+	Op  AccessOp
+	Key []byte
+}
+
+// TracingMapStore is a MapStore that records the sequence of accesses to an
+// underlying MapStore, e.g. to compare the accesses of operations in tests, or
+// to replay them in benchmarks.
+type TracingMapStore struct {
+	backing MapStore
+	trace   []Access
+}
+
+// NewTracingMapStore creates a TracingMapStore over a MapStore, and returns it
+// along with the MapStore to pass to the tree in place of the backing one,
+// which is the TracingMapStore itself.
+func NewTracingMapStore(backing MapStore) (*TracingMapStore, MapStore) {
+	tms := &TracingMapStore{backing: backing}
+	return tms, tms
+}
+
+func (tms *TracingMapStore) record(op AccessOp, key []byte) {
+	tms.trace = append(tms.trace, Access{Op: op, Key: append([]byte(nil), key...)})
+}
+
+// Get gets the value for a key.
+func (tms *TracingMapStore) Get(key []byte) ([]byte, error) {
+	tms.record(AccessGet, key)
+	return tms.backing.Get(key)
+}
+
+// Set updates the value for a key.
+func (tms *TracingMapStore) Set(key []byte, value []byte) error {
+	tms.record(AccessSet, key)
+	return tms.backing.Set(key, value)
+}
+
+// Delete deletes a key.
+func (tms *TracingMapStore) Delete(key []byte) error {
+	tms.record(AccessDelete, key)
+	return tms.backing.Delete(key)
+}
+
+// Trace returns the accesses recorded so far, in order.
+func (tms *TracingMapStore) Trace() []Access {
+	return tms.trace
+}
+
+// Reset clears the recorded accesses.
+func (tms *TracingMapStore) Reset() {
+	tms.trace = nil
+}
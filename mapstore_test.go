@@ -3,6 +3,7 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"reflect"
 	"testing"
 )
 
@@ -47,3 +48,85 @@ func TestSimpleMap(t *testing.T) {
 		t.Error("deleting a key did not return an error on a non-existent key")
 	}
 }
+
+func TestShardedMapStore(t *testing.T) {
+	shards := []MapStore{NewSimpleMap(), NewSimpleMap(), NewSimpleMap(), NewSimpleMap()}
+	store := NewShardedMapStore(shards)
+	smt := NewSparseMerkleTree(store, store, sha256.New())
+	reference := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	for i := 0; i < 100; i++ {
+		key := []byte{byte(i), 'k'}
+		if _, err := smt.Update(key, []byte{byte(i)}); err != nil {
+			t.Fatalf("returned error when updating sharded tree: %v", err)
+		}
+		reference.Update(key, []byte{byte(i)})
+	}
+	if !bytes.Equal(smt.Root(), reference.Root()) {
+		t.Error("sharded tree root does not match reference root")
+	}
+	for i, shard := range shards {
+		if len(shard.(*SimpleMap).m) == 0 {
+			t.Errorf("shard %d is empty", i)
+		}
+	}
+
+	// Reopen the tree over the same shards.
+	smt = ImportSparseMerkleTree(NewShardedMapStore(shards), NewShardedMapStore(shards), sha256.New(), smt.Root())
+	for i := 0; i < 100; i++ {
+		key := []byte{byte(i), 'k'}
+		value, err := smt.Get(key)
+		if err != nil {
+			t.Fatalf("returned error when getting from sharded tree: %v", err)
+		}
+		if !bytes.Equal(value, []byte{byte(i)}) {
+			t.Error("got incorrect value from sharded tree")
+		}
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving from sharded tree: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), key, value, sha256.New()) {
+			t.Error("proof from sharded tree failed to verify")
+		}
+	}
+
+	if err := store.Delete([]byte("missing")); err == nil {
+		t.Error("deleting a missing key did not return an error")
+	}
+}
+
+func TestTracingMapStore(t *testing.T) {
+	tracer, store := NewTracingMapStore(NewSimpleMap())
+	store.Set([]byte("key"), []byte("value"))
+	store.Get([]byte("key"))
+	store.Delete([]byte("key"))
+	if _, err := store.Get([]byte("key")); err == nil {
+		t.Error("did not return an error when getting a deleted key")
+	}
+	expected := []Access{
+		{Op: AccessSet, Key: []byte("key")},
+		{Op: AccessGet, Key: []byte("key")},
+		{Op: AccessDelete, Key: []byte("key")},
+		{Op: AccessGet, Key: []byte("key")},
+	}
+	if !reflect.DeepEqual(tracer.Trace(), expected) {
+		t.Error("trace does not match accesses")
+	}
+	tracer.Reset()
+
+	// Updating a key to its value reads the tree, but writes nothing.
+	smt := NewSparseMerkleTree(store, NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	tracer.Reset()
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if len(tracer.Trace()) == 0 {
+		t.Error("update did not read the nodes MapStore")
+	}
+	for _, access := range tracer.Trace() {
+		if access.Op != AccessGet {
+			t.Error("updating key to its value wrote to the nodes MapStore")
+		}
+	}
+}
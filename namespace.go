@@ -0,0 +1,28 @@
+package smt
+
+import (
+	"encoding/binary"
+)
+
+// NamespacedKey returns the key of a tree standing for a key in a namespace.
+// The namespace is prefixed with its length, so that no two pairs of a
+// namespace and a key give the same result. Proofs for a key in a namespace
+// are proofs for this key.
+func NamespacedKey(namespace []byte, key []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(namespace)+len(key))
+	n := binary.PutUvarint(buf, uint64(len(namespace)))
+	buf = append(buf[:n], namespace...)
+	return append(buf, key...)
+}
+
+// GetNamespaced gets the value of a key in a namespace, so that one tree can
+// hold the keys of several namespaces without collisions.
+func (smt *SparseMerkleTree) GetNamespaced(namespace []byte, key []byte) ([]byte, error) {
+	return smt.Get(NamespacedKey(namespace, key))
+}
+
+// UpdateNamespaced sets a new value for a key in a namespace, and sets and
+// returns the new root of the tree.
+func (smt *SparseMerkleTree) UpdateNamespaced(namespace []byte, key []byte, value []byte) ([]byte, error) {
+	return smt.Update(NamespacedKey(namespace, key), value)
+}
@@ -0,0 +1,68 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestNamespacedKey(t *testing.T) {
+	if bytes.Equal(NamespacedKey([]byte("ab"), []byte("c")), NamespacedKey([]byte("a"), []byte("bc"))) {
+		t.Error("different namespaces and keys give the same key")
+	}
+}
+
+func TestNamespaced(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.UpdateNamespaced([]byte("a"), []byte("testKey"), []byte("valueA"))
+	smt.UpdateNamespaced([]byte("b"), []byte("testKey"), []byte("valueB"))
+
+	value, err := smt.GetNamespaced([]byte("a"), []byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting namespaced key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("valueA")) {
+		t.Error("did not get value of key in namespace")
+	}
+	value, _ = smt.GetNamespaced([]byte("b"), []byte("testKey"))
+	if !bytes.Equal(value, []byte("valueB")) {
+		t.Error("did not get value of key in other namespace")
+	}
+	has, _ := smt.Has([]byte("testKey"))
+	if has {
+		t.Error("namespaced key is present outside of its namespace")
+	}
+
+	proof, _ := smt.Prove(NamespacedKey([]byte("a"), []byte("testKey")))
+	if !VerifyProof(proof, smt.Root(), NamespacedKey([]byte("a"), []byte("testKey")), []byte("valueA"), sha256.New()) {
+		t.Error("proof for namespaced key failed to verify")
+	}
+}
+
+func TestWithKeyNamespace(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	tenantA := NewSparseMerkleTree(smn, smv, sha256.New(), WithKeyNamespace([]byte("a")))
+	tenantB := NewSparseMerkleTree(smn, smv, sha256.New(), WithKeyNamespace([]byte("b")))
+	tenantA.Update([]byte("testKey"), []byte("valueA"))
+	tenantB.Update([]byte("testKey"), []byte("valueB"))
+
+	value, _ := tenantA.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("valueA")) {
+		t.Error("value of key was overwritten by other namespace")
+	}
+
+	// The tree is the same as one holding the namespaced keys.
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	plain.UpdateNamespaced([]byte("a"), []byte("testKey"), []byte("valueA"))
+	if !bytes.Equal(tenantA.Root(), plain.Root()) {
+		t.Error("root of namespaced tree does not match")
+	}
+
+	proof, _ := tenantA.Prove([]byte("testKey"))
+	if !VerifyProof(proof, tenantA.Root(), []byte("testKey"), []byte("valueA"), sha256.New(), WithKeyNamespace([]byte("a"))) {
+		t.Error("proof from namespaced tree failed to verify")
+	}
+	if VerifyProof(proof, tenantA.Root(), []byte("testKey"), []byte("valueA"), sha256.New()) {
+		t.Error("proof from namespaced tree verified without namespace")
+	}
+}
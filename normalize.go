@@ -0,0 +1,71 @@
+package smt
+
+import (
+	"bytes"
+)
+
+// normalizeNode rewrites the subtree rooted at node, which is at the given
+// depth, into canonical form, and returns its new root and whether the root is
+// a leaf. Subtrees that are already canonical are left untouched.
+func (smt *SparseMerkleTree) normalizeNode(node []byte, depth int) ([]byte, bool, error) {
+	if bytes.Equal(node, smt.th.placeholder()) {
+		return node, false, nil
+	}
+	data, err := smt.getNode(node)
+	if err != nil {
+		return nil, false, err
+	}
+	if smt.th.isLeaf(data) {
+		return node, true, nil
+	}
+	if depth >= smt.depth() {
+		return nil, false, ErrCycleDetected
+	}
+
+	leftNode, rightNode := smt.th.parseNode(data)
+	newLeft, leftIsLeaf, err := smt.normalizeNode(leftNode, depth+1)
+	if err != nil {
+		return nil, false, err
+	}
+	newRight, rightIsLeaf, err := smt.normalizeNode(rightNode, depth+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	leftEmpty := bytes.Equal(newLeft, smt.th.placeholder())
+	rightEmpty := bytes.Equal(newRight, smt.th.placeholder())
+	if bytes.Equal(newLeft, leftNode) && bytes.Equal(newRight, rightNode) &&
+		!(leftEmpty && (rightEmpty || rightIsLeaf)) && !(rightEmpty && leftIsLeaf) {
+		// The node is already canonical.
+		return node, false, nil
+	}
+
+	if err := smt.deleteNode(node); err != nil {
+		return nil, false, err
+	}
+	return smt.mergeNodes(newLeft, newRight, leftIsLeaf, rightIsLeaf, depth)
+}
+
+// Normalize rewrites the tree into the canonical form produced by this
+// package, in which a leaf never has a placeholder sibling, and returns true
+// if the root changed. This is needed for trees imported from implementations
+// that do not collapse leaves in the same way, for the roots of later updates
+// to match. Only the nodes that are not canonical are rewritten.
+func (smt *SparseMerkleTree) Normalize() (bool, error) {
+	if smt.closed {
+		return false, ErrClosed
+	}
+	newRoot, _, err := smt.normalizeNode(smt.root, 0)
+	if err == nil {
+		err = smt.commitNodes()
+	}
+	if err != nil {
+		smt.discardNodes()
+		return false, err
+	}
+	if bytes.Equal(newRoot, smt.root) {
+		return false, nil
+	}
+	smt.root = newRoot
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	changed, err := smt.Normalize()
+	if err != nil {
+		t.Errorf("returned error when normalizing canonical tree: %v", err)
+	}
+	if changed {
+		t.Error("normalizing canonical tree changed root")
+	}
+
+	// Build a tree whose only leaf is not collapsed to the root.
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt = NewSparseMerkleTree(smn, smv, sha256.New())
+	path := smt.th.path([]byte("testKey"))
+	node, data := smt.th.digestLeaf(path, smt.th.digest([]byte("testValue")))
+	smn.Set(node, data)
+	smv.Set(path, []byte("testValue"))
+	for depth := 2; depth >= 0; depth-- {
+		if getBitAtFromMSB(path, depth) == right {
+			node, data = smt.th.digestNode(smt.th.placeholder(), node, depth)
+		} else {
+			node, data = smt.th.digestNode(node, smt.th.placeholder(), depth)
+		}
+		smn.Set(node, data)
+	}
+	smt.SetRoot(node)
+
+	changed, err = smt.Normalize()
+	if err != nil {
+		t.Errorf("returned error when normalizing tree: %v", err)
+	}
+	if !changed {
+		t.Error("normalizing non-canonical tree did not change root")
+	}
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	expected.Update([]byte("testKey"), []byte("testValue"))
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("normalized root does not match canonical root")
+	}
+	if len(smn.m) != 1 {
+		t.Errorf("expected 1 node after normalizing, got: %d", len(smn.m))
+	}
+
+	// Later updates match the canonical tree.
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	expected.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Delete([]byte("testKey"))
+	expected.Delete([]byte("testKey"))
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root after updating normalized tree does not match")
+	}
+}
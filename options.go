@@ -1,4 +1,318 @@
 package smt
 
+import (
+	"io"
+)
+
 // Option is a function that configures SMT.
 type Option func(*SparseMerkleTree)
+
+// WithLeafSalt incorporates a salt into the digest of every leaf, so that an
+// observer of the nodes MapStore cannot test guesses for the presence of a
+// path by hashing candidate leaves. The salt changes all leaf digests, and
+// hence the root; proofs must be verified with the same salt.
+func WithLeafSalt(salt []byte) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.leafSalt = salt
+	}
+}
+
+// WithDomainTags hashes a tag byte before the serialization of every node, one
+// for leaves and another for inner nodes, so that the digests of the two kinds
+// of nodes are computed in separate domains, whatever the codec. The tags are
+// not part of the serialized nodes. This changes every digest and root, except
+// the placeholder of empty subtrees, and the same option must be passed when
+// verifying proofs.
+func WithDomainTags(leafTag, innerTag byte) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.leafTag = []byte{leafTag}
+		smt.th.innerTag = []byte{innerTag}
+	}
+}
+
+// WithOrphanCallback sets a function to be called with the digest of each
+// node that is orphaned and removed from the nodes MapStore.
+func WithOrphanCallback(fn func(digest []byte)) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.orphanCallback = fn
+	}
+}
+
+// WithPersistCallback sets a function to be called with the digest and data
+// of each node written to the nodes MapStore.
+func WithPersistCallback(fn func(digest, data []byte)) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.persistCallback = fn
+	}
+}
+
+// WithCodec sets the codec used to serialize and parse nodes, e.g. to read
+// and write a nodes MapStore produced by another implementation. The default
+// codec prefixes leaf and inner nodes with a zero and a one byte respectively.
+func WithCodec(codec NodeCodec) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.codec = codec
+	}
+}
+
+// WithNoOrphanTracking disables the removal of orphaned nodes from the nodes
+// MapStore, for trees that are built once and never mutated afterwards.
+// Nodes of previous roots are left in place, and listed by PendingOrphans, and
+// deleting a key returns ErrNoOrphanTracking.
+func WithNoOrphanTracking() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.noOrphanTracking = true
+	}
+}
+
+// WithEmptyRootNil makes Root return nil rather than the placeholder when the
+// tree is empty. The placeholder remains the canonical root of an empty tree:
+// it is what proofs commit to, and nil is accepted wherever a root is
+// expected, as an alias for the placeholder.
+func WithEmptyRootNil() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.emptyRootNil = true
+	}
+}
+
+// WithBatchWriter makes the tree accumulate all writes and deletes of nodes
+// performed by an operation into a batch, which is committed once at the end
+// of the operation. The batch is discarded if the operation fails. Values
+// are still written to the values MapStore directly.
+func WithBatchWriter(batch BatchWriter) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.batch = batch
+	}
+}
+
+// WithVerifyOnRead makes the tree check that every node read from the nodes
+// MapStore hashes to the digest it was read by, returning ErrNodeCorrupt
+// otherwise. This detects corrupt or tampered stores, at the cost of hashing
+// each node read.
+func WithVerifyOnRead() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.verifyOnRead = true
+	}
+}
+
+// WithAccessTracking makes the tree record the last n keys accessed by Get,
+// Update and Delete, which are returned by RecentKeys.
+func WithAccessTracking(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.accessLogSize = n
+	}
+}
+
+// WithPathLength truncates the paths of keys to their first n bytes, which
+// must be at most the size of the hasher's digests, so that the tree is only
+// n*8 levels deep and proofs are correspondingly smaller. This weakens
+// collision resistance: keys whose digests share their first n bytes have the
+// same path, so they are the same entry in the tree, and the last value
+// written for any of them is the value of all of them. The same option must
+// be passed when verifying proofs.
+func WithPathLength(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.pathLen = n
+	}
+}
+
+// WithKeyNamespace makes the tree prepend a namespace to every key before
+// hashing it into its path, as NamespacedKey does, so that the keys of trees
+// with different namespaces sharing the same stores cannot collide. The same
+// option must be passed when verifying proofs.
+func WithKeyNamespace(namespace []byte) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.keyNamespace = append([]byte{}, namespace...)
+	}
+}
+
+// WithRootLog makes Update and Delete append each new root of the tree to a
+// RootLog. If the root cannot be appended, the error is returned, but the
+// tree is left at its new root, whose nodes are already written.
+func WithRootLog(log *RootLog) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.rootLog = log
+	}
+}
+
+// WithCopyValues makes Get, GetBatch and LightProof return copies of values,
+// rather than the slices held by the values MapStore, which callers could
+// otherwise corrupt by modifying them.
+func WithCopyValues() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.copyValues = true
+	}
+}
+
+// WithDefaultValue sets the value of absent keys, which is empty by default.
+// Get returns it for absent keys, updating a key to it deletes the key, and
+// proofs for it are non-membership proofs. The same option must be passed
+// when verifying proofs.
+func WithDefaultValue(value []byte) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.defaultValue = append([]byte{}, value...)
+	}
+}
+
+// WithEmptyValueDeletes makes updating a key to an empty value delete it, like
+// updating it to the default value, for trees created with WithDefaultValue.
+// By default, the default value is empty, and so are the values of deleted
+// keys; with another default value, an empty value is stored like any other.
+// The same option must be passed when verifying proofs, so that a proof for
+// an empty value is verified as a non-membership proof.
+func WithEmptyValueDeletes() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.emptyValueDeletes = true
+	}
+}
+
+// WithDepthBoundDigests serializes inner nodes with their depth in the tree,
+// so that the digest of an inner node cannot be presented at another depth in
+// a crafted proof. Leaves move up and down the tree as keys are deleted and
+// inserted, so they are not bound to a depth; they are bound to their paths.
+// This changes every inner node digest and root, and the same option must be
+// passed when verifying proofs.
+func WithDepthBoundDigests() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.depthBound = true
+	}
+}
+
+// WithFormatVersion prepends a version byte to every serialized node, so that
+// nodes written in an incompatible format are detected: reading a node with
+// another version returns ErrFormatVersionMismatch. The version is part of
+// the data hashed for each node, so it changes every digest and root, except
+// the placeholder of empty subtrees, and the same option must be passed when
+// verifying proofs.
+func WithFormatVersion(version byte) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.formatVersion = []byte{version}
+	}
+}
+
+// WithTombstones makes Delete replace the leaf of a key with a tombstone,
+// rather than removing it, so that the deletion remains provable with
+// VerifyTombstoneProof. Get returns ErrKeyDeleted for deleted keys, and Purge
+// removes a key along with its tombstone.
+func WithTombstones() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.tombstones = true
+	}
+}
+
+// WithVersionedLeaves makes the tree count its updates, and store the count at
+// which each key was last set along with its value, so that GetVersioned can
+// tell how fresh a value is. The version is part of the leaf's value hash, so
+// proofs cover it, and must be verified with VerifyVersionedProof. The count
+// is kept in memory and starts from 0, or from the version given to
+// WithVersion.
+func WithVersionedLeaves() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.versionedLeaves = true
+	}
+}
+
+// WithVersion sets the version of the last update of a tree with versioned
+// leaves, e.g. as returned by Version before the tree was last closed.
+func WithVersion(version uint64) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.version = version
+	}
+}
+
+// WithRejectDuplicatesInBatch makes BuildSMT return a DuplicateKeyError if
+// several of its keys have the same path, rather than using the value of the
+// last one.
+func WithRejectDuplicatesInBatch() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.rejectDuplicates = true
+	}
+}
+
+// WithParallelSave makes BuildSMT write the nodes and values of the tree to
+// the MapStores from the given number of goroutines, while the tree is hashed,
+// which speeds up building large trees over stores with slow writes. Both
+// MapStores must implement ConcurrentMapStore, or BuildSMT returns
+// ErrConcurrentStoreRequired; with WithBatchWriter, only the values are
+// written concurrently, and only the values MapStore must implement it.
+func WithParallelSave(workers int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.saveWorkers = workers
+	}
+}
+
+// WithLogger makes Get, Update and Delete, and the methods calling them, e.g.
+// Has, pass a TraceEvent describing each operation to fn once it is done, e.g.
+// to find hot keys or operations that read the stores excessively.
+func WithLogger(fn func(event TraceEvent)) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.logger = fn
+	}
+}
+
+// WithEphemeral makes the tree keep its nodes and values in new in-memory
+// MapStores, in place of the MapStores it is created with, which may be nil,
+// for transient trees that must be provable but never persisted. Orphaned
+// nodes are removed from memory as usual. It must not be combined with options
+// that write to other stores, such as WithBatchWriter or WithWAL.
+func WithEphemeral() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.ephemeral = true
+	}
+}
+
+// WithFullDepthProofs makes Prove and its variants pad the side nodes of proofs
+// with placeholders below the leaf, so that every proof has one side node per
+// bit of the path, for verifiers that expect proofs of fixed length. Proofs
+// are verified the same whether they are padded or not.
+func WithFullDepthProofs() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.fullDepthProofs = true
+	}
+}
+
+// WithWAL makes Update, Delete, UpdateMany, DeletePath and Purge append a
+// record of each key and value to a write-ahead log before applying it, once
+// the operation is validated, so that operations applied since the root of
+// the tree was last persisted can be recovered with ReplayWAL after a crash. Close truncates the log if it implements
+// WALTruncater, so the root must be persisted before the tree is closed.
+func WithWAL(w io.Writer) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.wal = w
+	}
+}
+
+// WithUndo makes the tree record the previous value of the key of each of up
+// to limit calls to Update and Delete, so that they can be reverted with
+// Undo, and applied again with Redo. UpdateMany clears the recorded
+// operations, and changing the root by other means, e.g. SetRoot, makes them
+// meaningless. Undo does not restore the previous root of a tree created with
+// WithTombstones, as reverting an insertion leaves a tombstone.
+func WithUndo(limit int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.undoLimit = limit
+	}
+}
+
+// WithRejectEmptyKey makes Get, Update, Delete and their variants return
+// ErrEmptyKey for empty keys, for applications where an empty key is always a
+// bug. By default, the empty key is an ordinary key.
+func WithRejectEmptyKey() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.rejectEmptyKey = true
+	}
+}
+
+// WithAbsenceBloom keeps an in-memory bloom filter of the given number of bits
+// over the paths of the leaves of the tree, so that Get and Has return the
+// default value for most absent keys without reading the stores. Keys that are
+// present, and a fraction of absent keys that grows as the filter fills, are
+// looked up as usual. Deleted paths remain in the filter. The filter is kept
+// up to date by Update and its variants; after the root is set by other means,
+// e.g. ImportSparseMerkleTree or SetRoot, it is not used until it is rebuilt
+// with RebuildAbsenceBloom.
+func WithAbsenceBloom(bits int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.bloomBits = bits
+	}
+}
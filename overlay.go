@@ -0,0 +1,45 @@
+package smt
+
+// overlayMapStore is a MapStore that buffers writes in memory on top of an
+// underlying MapStore, which is never modified.
+type overlayMapStore struct {
+	store   MapStore
+	sets    map[string][]byte
+	deletes map[string]bool
+}
+
+func newOverlayMapStore(store MapStore) *overlayMapStore {
+	return &overlayMapStore{
+		store:   store,
+		sets:    make(map[string][]byte),
+		deletes: make(map[string]bool),
+	}
+}
+
+// Get gets the value for a key.
+func (om *overlayMapStore) Get(key []byte) ([]byte, error) {
+	if value, ok := om.sets[string(key)]; ok {
+		return value, nil
+	}
+	if om.deletes[string(key)] {
+		return nil, &InvalidKeyError{Key: key}
+	}
+	return om.store.Get(key)
+}
+
+// Set updates the value for a key.
+func (om *overlayMapStore) Set(key []byte, value []byte) error {
+	delete(om.deletes, string(key))
+	om.sets[string(key)] = value
+	return nil
+}
+
+// Delete deletes a key.
+func (om *overlayMapStore) Delete(key []byte) error {
+	if _, err := om.Get(key); err != nil {
+		return err
+	}
+	delete(om.sets, string(key))
+	om.deletes[string(key)] = true
+	return nil
+}
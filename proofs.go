@@ -2,6 +2,7 @@ package smt
 
 import (
 	"bytes"
+	"fmt"
 	"hash"
 	"math"
 )
@@ -22,32 +23,75 @@ type SparseMerkleProof struct {
 }
 
 func (proof *SparseMerkleProof) sanityCheck(th *treeHasher) bool {
+	return proof.validate(th) == nil
+}
+
+func (proof *SparseMerkleProof) validate(th *treeHasher) error {
 	// Do a basic sanity check on the proof, so that a malicious proof cannot
 	// cause the verifier to fatally exit (e.g. due to an index out-of-range
 	// error) or cause a CPU DoS attack.
 
 	// Check that the number of supplied sidenodes does not exceed the maximum possible.
-	if len(proof.SideNodes) > th.pathSize()*8 ||
+	if len(proof.SideNodes) > th.pathSize()*8 {
+		return fmt.Errorf("%w: %d side nodes exceed tree depth %d", ErrBadProof, len(proof.SideNodes), th.pathSize()*8)
+	}
 
-		// Check that leaf data for non-membership proofs is the correct size.
-		(proof.NonMembershipLeafData != nil && len(proof.NonMembershipLeafData) != len(leafPrefix)+th.pathSize()+th.hasher.Size()) {
-		return false
+	// Check that leaf data for non-membership proofs is the correct size.
+	if proof.NonMembershipLeafData != nil && len(proof.NonMembershipLeafData) != th.leafSize() {
+		return fmt.Errorf("%w: non-membership leaf data has size %d", ErrBadProof, len(proof.NonMembershipLeafData))
 	}
 
 	// Check that all supplied sidenodes are the correct size.
-	for _, v := range proof.SideNodes {
+	for i, v := range proof.SideNodes {
 		if len(v) != th.hasher.Size() {
-			return false
+			return fmt.Errorf("%w: side node %d has size %d", ErrBadProof, i, len(v))
 		}
 	}
 
 	// Check that the sibling data hashes to the first side node if not nil
-	if proof.SiblingData == nil || len(proof.SideNodes) == 0 {
-		return true
+	sideNodes := proof.trimPadding(th, 0).SideNodes
+	if proof.SiblingData == nil || len(sideNodes) == 0 {
+		return nil
+	}
+
+	siblingHash := th.digestData(proof.SiblingData)
+	if !bytes.Equal(sideNodes[0], siblingHash) {
+		return fmt.Errorf("%w: sibling data does not match first side node", ErrBadProof)
 	}
 
-	siblingHash := th.digest(proof.SiblingData)
-	return bytes.Equal(proof.SideNodes[0], siblingHash)
+	// Check that the sibling data is a well-formed node.
+	if th.isLeaf(proof.SiblingData) {
+		if _, _, err := th.parseLeaf(proof.SiblingData); err != nil {
+			return fmt.Errorf("%w: sibling data is not a valid leaf", ErrBadProof)
+		}
+	} else if len(proof.SiblingData) != th.nodeSize() {
+		return fmt.Errorf("%w: sibling data has size %d", ErrBadProof, len(proof.SiblingData))
+	}
+	return nil
+}
+
+// trimPadding returns the proof without the placeholder side nodes below the
+// leaf that pad proofs generated with WithFullDepthProofs, if it has one side
+// node for each bit of the path below the given depth. A leaf never has a
+// placeholder sibling, so such side nodes can only be padding.
+func (proof *SparseMerkleProof) trimPadding(th *treeHasher, depth int) SparseMerkleProof {
+	trimmed := *proof
+	if len(trimmed.SideNodes) != th.pathSize()*8-depth {
+		return trimmed
+	}
+	for len(trimmed.SideNodes) > 0 && bytes.Equal(trimmed.SideNodes[0], th.placeholder()) {
+		trimmed.SideNodes = trimmed.SideNodes[1:]
+	}
+	return trimmed
+}
+
+// ValidateProof checks that a Merkle proof is well-formed for the given hasher
+// and options, without verifying it against a root: the number of side nodes
+// must not exceed the depth of the tree, and the side nodes, leaf data and
+// sibling data must have the expected sizes. The returned error wraps
+// ErrBadProof and describes the first problem found.
+func ValidateProof(proof SparseMerkleProof, hasher hash.Hash, options ...Option) error {
+	return proof.validate(newVerifierTreeHasher(hasher, options))
 }
 
 // SparseCompactMerkleProof is a compact Merkle proof for an element in a SparseMerkleTree.
@@ -94,32 +138,133 @@ func (proof *SparseCompactMerkleProof) sanityCheck(th *treeHasher) bool {
 		return false
 	}
 
+	// Check that all supplied sidenodes are the correct size before they are
+	// decompacted.
+	for _, v := range proof.SideNodes {
+		if len(v) != th.hasher.Size() {
+			return false
+		}
+	}
+
 	return true
 }
 
-// VerifyProof verifies a Merkle proof.
-func VerifyProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) bool {
-	result, _ := verifyProofWithUpdates(proof, root, key, value, hasher)
+// newVerifierTreeHasher returns a treeHasher configured with the given
+// options, for use by functions that operate on proofs rather than on a tree.
+func newVerifierTreeHasher(hasher hash.Hash, options []Option) *treeHasher {
+	smt := SparseMerkleTree{th: *newTreeHasher(hasher)}
+	for _, option := range options {
+		option(&smt)
+	}
+	return &smt.th
+}
+
+// VerifyProof verifies a Merkle proof. The options must match those of the
+// tree the proof was generated from.
+func VerifyProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	result, _ := verifyProofWithUpdates(proof, root, key, value, newVerifierTreeHasher(hasher, options))
 	return result
 }
 
-func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) (bool, [][][]byte) {
-	th := newTreeHasher(hasher)
-	path := th.path(key)
+// VerifyCommonAncestorProof verifies a proof generated by ProveCommonAncestor,
+// that the node with the given digest is at the given depth under the root,
+// on the common prefix of the paths of the keys.
+func VerifyCommonAncestorProof(proof SparseMerkleProof, root []byte, keys [][]byte, ancestorDepth int, ancestorDigest []byte, hasher hash.Hash, options ...Option) bool {
+	th := newVerifierTreeHasher(hasher, options)
+	if len(keys) == 0 || ancestorDepth < 0 || ancestorDepth > th.pathSize()*8 || len(proof.SideNodes) != ancestorDepth {
+		return false
+	}
+	path := th.path(keys[0])
+	for _, key := range keys[1:] {
+		if countCommonPrefix(path, th.path(key)) < ancestorDepth {
+			return false
+		}
+	}
+	if len(root) == 0 {
+		root = th.placeholder()
+	}
 
+	currentHash := ancestorDigest
+	for i, sideNode := range proof.SideNodes {
+		if len(sideNode) != th.hasher.Size() {
+			return false
+		}
+		nodeDepth := ancestorDepth - 1 - i
+		if getBitAtFromMSB(path, nodeDepth) == right {
+			currentHash, _ = th.digestNode(sideNode, currentHash, nodeDepth)
+		} else {
+			currentHash, _ = th.digestNode(currentHash, sideNode, nodeDepth)
+		}
+	}
+	return bytes.Equal(currentHash, root)
+}
+
+// VerifyProofToDepth verifies a Merkle proof generated by ProveToDepth against
+// the root of the subtree at the given depth.
+func VerifyProofToDepth(proof SparseMerkleProof, subtreeRoot []byte, depth int, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	result, _ := verifyProofAtDepth(proof, subtreeRoot, depth, key, value, newVerifierTreeHasher(hasher, options))
+	return result
+}
+
+func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, value []byte, th *treeHasher) (bool, [][][]byte) {
+	return verifyProofAtDepth(proof, root, 0, key, value, th)
+}
+
+// verifyProofAtDepth verifies a Merkle proof against the root of the subtree
+// at the given depth, returning the digests and data of the nodes computed
+// along the way.
+func verifyProofAtDepth(proof SparseMerkleProof, root []byte, depth int, key []byte, value []byte, th *treeHasher) (bool, [][][]byte) {
+	var valueHash []byte
+	if !th.isDefault(value) {
+		valueHash = th.digest(value)
+	}
+	return verifyProofForPath(proof, root, depth, th.path(key), valueHash, th)
+}
+
+// verifyProofForPath verifies a Merkle proof for the leaf with the given path
+// and value hash, rather than key and value. A nil value hash verifies a
+// non-membership proof.
+func verifyProofForPath(proof SparseMerkleProof, root []byte, depth int, path []byte, valueHash []byte, th *treeHasher) (bool, [][][]byte) {
+	if len(path) != th.pathSize() {
+		return false, nil
+	}
 	if !proof.sanityCheck(th) {
 		return false, nil
 	}
+	if depth < 0 || len(proof.SideNodes) > th.pathSize()*8-depth {
+		return false, nil
+	}
+	proof = proof.trimPadding(th, depth)
+
+	// If the sibling of the leaf is a leaf, it must be on the other side of
+	// their parent: its path has the same bits as the path down to the
+	// parent, and a different next bit.
+	if proof.SiblingData != nil && len(proof.SideNodes) > 0 && th.isLeaf(proof.SiblingData) {
+		siblingPath, _, _ := th.parseLeaf(proof.SiblingData)
+		parentDepth := depth + len(proof.SideNodes) - 1
+		if !hasPrefixBits(siblingPath, path, parentDepth) || getBitAtFromMSB(siblingPath, parentDepth) == getBitAtFromMSB(path, parentDepth) {
+			return false, nil
+		}
+	}
+
+	if len(root) == 0 || bytes.Equal(root, th.placeholder()) {
+		// The tree is empty, so only an empty non-membership proof is valid.
+		isEmpty := len(proof.SideNodes) == 0 && proof.NonMembershipLeafData == nil
+		return isEmpty && valueHash == nil, nil
+	}
 
 	var updates [][][]byte
 
 	// Determine what the leaf hash should be.
 	var currentHash, currentData []byte
-	if bytes.Equal(value, defaultValue) { // Non-membership proof.
+	if valueHash == nil { // Non-membership proof.
 		if proof.NonMembershipLeafData == nil { // Leaf is a placeholder value.
 			currentHash = th.placeholder()
 		} else { // Leaf is an unrelated leaf.
-			actualPath, valueHash := th.parseLeaf(proof.NonMembershipLeafData)
+			actualPath, valueHash, err := th.parseLeaf(proof.NonMembershipLeafData)
+			if err != nil {
+				return false, nil
+			}
 			if bytes.Equal(actualPath, path) {
 				// This is not an unrelated leaf; non-membership proof failed.
 				return false, nil
@@ -131,7 +276,6 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 			updates = append(updates, update)
 		}
 	} else { // Membership proof.
-		valueHash := th.digest(value)
 		currentHash, currentData = th.digestLeaf(path, valueHash)
 		update := make([][]byte, 2)
 		update[0], update[1] = currentHash, currentData
@@ -140,13 +284,14 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 
 	// Recompute root.
 	for i := 0; i < len(proof.SideNodes); i++ {
-		node := make([]byte, th.pathSize())
+		node := make([]byte, th.hasher.Size())
 		copy(node, proof.SideNodes[i])
 
-		if getBitAtFromMSB(path, len(proof.SideNodes)-1-i) == right {
-			currentHash, currentData = th.digestNode(node, currentHash)
+		nodeDepth := depth + len(proof.SideNodes) - 1 - i
+		if getBitAtFromMSB(path, nodeDepth) == right {
+			currentHash, currentData = th.digestNode(node, currentHash, nodeDepth)
 		} else {
-			currentHash, currentData = th.digestNode(currentHash, node)
+			currentHash, currentData = th.digestNode(currentHash, node, nodeDepth)
 		}
 
 		update := make([][]byte, 2)
@@ -158,18 +303,22 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 }
 
 // VerifyCompactProof verifies a compacted Merkle proof.
-func VerifyCompactProof(proof SparseCompactMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) bool {
-	decompactedProof, err := DecompactProof(proof, hasher)
+func VerifyCompactProof(proof SparseCompactMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	th := newVerifierTreeHasher(hasher, options)
+	decompactedProof, err := decompactProof(proof, th)
 	if err != nil {
 		return false
 	}
-	return VerifyProof(decompactedProof, root, key, value, hasher)
+	result, _ := verifyProofWithUpdates(decompactedProof, root, key, value, th)
+	return result
 }
 
 // CompactProof compacts a proof, to reduce its size.
-func CompactProof(proof SparseMerkleProof, hasher hash.Hash) (SparseCompactMerkleProof, error) {
-	th := newTreeHasher(hasher)
+func CompactProof(proof SparseMerkleProof, hasher hash.Hash, options ...Option) (SparseCompactMerkleProof, error) {
+	return compactProof(proof, newVerifierTreeHasher(hasher, options))
+}
 
+func compactProof(proof SparseMerkleProof, th *treeHasher) (SparseCompactMerkleProof, error) {
 	if !proof.sanityCheck(th) {
 		return SparseCompactMerkleProof{}, ErrBadProof
 	}
@@ -196,9 +345,11 @@ func CompactProof(proof SparseMerkleProof, hasher hash.Hash) (SparseCompactMerkl
 }
 
 // DecompactProof decompacts a proof, so that it can be used for VerifyProof.
-func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash) (SparseMerkleProof, error) {
-	th := newTreeHasher(hasher)
+func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash, options ...Option) (SparseMerkleProof, error) {
+	return decompactProof(proof, newVerifierTreeHasher(hasher, options))
+}
 
+func decompactProof(proof SparseCompactMerkleProof, th *treeHasher) (SparseMerkleProof, error) {
 	if !proof.sanityCheck(th) {
 		return SparseMerkleProof{}, ErrBadProof
 	}
@@ -220,3 +371,19 @@ func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash) (SparseMer
 		SiblingData:           proof.SiblingData,
 	}, nil
 }
+
+// VerifyAdjacentProof verifies a proof generated by ProveAdjacent, that keyA
+// and keyB have the given values, and that their leaves are siblings.
+func VerifyAdjacentProof(proof SparseMerkleProof, root []byte, keyA []byte, valueA []byte, keyB []byte, valueB []byte, hasher hash.Hash, options ...Option) bool {
+	th := newVerifierTreeHasher(hasher, options)
+	if len(proof.SideNodes) == 0 || th.isDefault(valueA) || th.isDefault(valueB) {
+		return false
+	}
+	// The sibling of keyA's leaf must be keyB's leaf.
+	siblingHash, _ := th.digestLeaf(th.path(keyB), th.digest(valueB))
+	if !bytes.Equal(proof.SideNodes[0], siblingHash) {
+		return false
+	}
+	result, _ := verifyProofWithUpdates(proof, root, keyA, valueA, th)
+	return result
+}
@@ -3,8 +3,10 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"hash"
 	"math/rand"
+	"strconv"
 	"testing"
 )
 
@@ -279,3 +281,664 @@ func checkCompactEquivalence(t *testing.T, proof SparseMerkleProof, hasher hash.
 		t.Error("de-compacted proof does not match original proof")
 	}
 }
+
+// Test that proofs from a salted tree verify only with the same salt.
+func TestProofsLeafSalt(t *testing.T) {
+	salt := []byte("salt")
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	salted := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithLeafSalt(salt))
+
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	salted.Update([]byte("testKey1"), []byte("testValue1"))
+	salted.Update([]byte("testKey2"), []byte("testValue2"))
+	root, _ := smt.Update([]byte("testKey2"), []byte("testValue2"))
+	saltedRoot := salted.Root()
+	if bytes.Equal(root, saltedRoot) {
+		t.Error("salted tree has the same root as unsalted tree")
+	}
+
+	proof, err := salted.ProveUpdatable([]byte("testKey1"))
+	if err != nil {
+		t.Errorf("error returned when trying to prove inclusion: %v", err)
+	}
+	if !VerifyProof(proof, saltedRoot, []byte("testKey1"), []byte("testValue1"), sha256.New(), WithLeafSalt(salt)) {
+		t.Error("valid proof failed to verify")
+	}
+	if VerifyProof(proof, saltedRoot, []byte("testKey1"), []byte("testValue1"), sha256.New()) {
+		t.Error("salted proof verified without salt")
+	}
+	if VerifyProof(proof, saltedRoot, []byte("testKey1"), []byte("testValue1"), sha256.New(), WithLeafSalt([]byte("pepper"))) {
+		t.Error("salted proof verified with the wrong salt")
+	}
+
+	compactProof, err := salted.ProveCompact([]byte("testKey3"))
+	if err != nil {
+		t.Errorf("error returned when trying to prove non-inclusion: %v", err)
+	}
+	if !VerifyCompactProof(compactProof, saltedRoot, []byte("testKey3"), defaultValue, sha256.New(), WithLeafSalt(salt)) {
+		t.Error("valid compact proof failed to verify")
+	}
+
+	// Test that an imported salted tree reaches the same roots.
+	imported := ImportSparseMerkleTree(salted.nodes, salted.values, sha256.New(), saltedRoot, WithLeafSalt(salt))
+	root, err = imported.Update([]byte("testKey3"), []byte("testValue3"))
+	if err != nil {
+		t.Errorf("returned error when updating imported tree: %v", err)
+	}
+	root, err = imported.Delete([]byte("testKey3"))
+	if err != nil {
+		t.Errorf("returned error when deleting key: %v", err)
+	}
+	if !bytes.Equal(root, saltedRoot) {
+		t.Error("imported salted tree root is not as expected after deleting key")
+	}
+}
+
+// Test that sibling digests are the side nodes of a proof in top-down order.
+func TestSiblingDigests(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	siblings, err := smt.SiblingDigests([]byte("testKey1"))
+	if err != nil {
+		t.Errorf("returned error when getting sibling digests: %v", err)
+	}
+	if len(siblings) != 0 {
+		t.Error("expected no sibling digests in empty tree")
+	}
+
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey3"), []byte("testValue3"))
+	smt.Update([]byte("foo"), []byte("testValue4"))
+
+	for _, key := range []string{"testKey1", "foo", "testKey5"} {
+		proof, _ := smt.Prove([]byte(key))
+		siblings, err := smt.SiblingDigests([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when getting sibling digests: %v", err)
+		}
+		if len(siblings) != len(proof.SideNodes) {
+			t.Fatalf("expected %d sibling digests, got: %d", len(proof.SideNodes), len(siblings))
+		}
+		for i := range siblings {
+			if !bytes.Equal(siblings[i], proof.SideNodes[len(siblings)-1-i]) {
+				t.Error("sibling digests are not the reversed side nodes of the proof")
+			}
+		}
+	}
+}
+
+// Test non-membership proofs against an empty tree and a single-leaf tree.
+func TestProofsEmptyTree(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	placeholder := smt.th.placeholder()
+
+	for _, root := range [][]byte{placeholder, nil} {
+		proof, err := smt.ProveForRoot([]byte("testKey"), root)
+		if err != nil {
+			t.Errorf("returned error when proving key in empty tree: %v", err)
+		}
+		if len(proof.SideNodes) != 0 || proof.NonMembershipLeafData != nil {
+			t.Error("proof against empty tree is not empty")
+		}
+		if !VerifyProof(proof, root, []byte("testKey"), defaultValue, sha256.New()) {
+			t.Error("valid proof against empty tree failed to verify")
+		}
+		if VerifyProof(proof, root, []byte("testKey"), []byte("testValue"), sha256.New()) {
+			t.Error("membership proof against empty tree verified")
+		}
+	}
+
+	// A proof with side nodes never proves against an empty tree.
+	proof := SparseMerkleProof{SideNodes: [][]byte{placeholder}}
+	if VerifyProof(proof, placeholder, []byte("testKey"), defaultValue, sha256.New()) {
+		t.Error("proof with side nodes verified against empty tree")
+	}
+
+	root, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+	proof, err := smt.Prove([]byte("testKey2"))
+	if err != nil {
+		t.Errorf("returned error when proving key in single-leaf tree: %v", err)
+	}
+	if len(proof.SideNodes) != 0 || proof.NonMembershipLeafData == nil {
+		t.Error("unexpected non-membership proof in single-leaf tree")
+	}
+	if !VerifyProof(proof, root, []byte("testKey2"), defaultValue, sha256.New()) {
+		t.Error("valid non-membership proof in single-leaf tree failed to verify")
+	}
+	if VerifyProof(proof, placeholder, []byte("testKey2"), defaultValue, sha256.New()) {
+		t.Error("non-membership proof in single-leaf tree verified against empty tree")
+	}
+}
+
+// Test proofs against the root of a subtree at a given depth.
+func TestProveToDepth(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	for _, key := range []string{"3", "11", "testKey"} {
+		value := []byte(key)
+		if key == "testKey" {
+			value = defaultValue
+		}
+		fullProof, _ := smt.Prove([]byte(key))
+		for depth := 0; depth <= len(fullProof.SideNodes); depth++ {
+			proof, subtreeRoot, err := smt.ProveToDepth([]byte(key), depth)
+			if err != nil {
+				t.Fatalf("returned error when proving key to depth %d: %v", depth, err)
+			}
+			if depth == 0 && !bytes.Equal(subtreeRoot, smt.Root()) {
+				t.Error("subtree root at depth 0 is not the root")
+			}
+			if len(proof.SideNodes) != len(fullProof.SideNodes)-depth {
+				t.Errorf("expected %d side nodes, got: %d", len(fullProof.SideNodes)-depth, len(proof.SideNodes))
+			}
+			if !VerifyProofToDepth(proof, subtreeRoot, depth, []byte(key), value, sha256.New()) {
+				t.Errorf("valid proof to depth %d failed to verify", depth)
+			}
+			if depth > 0 && VerifyProofToDepth(proof, smt.Root(), depth, []byte(key), value, sha256.New()) {
+				t.Error("proof to depth verified against the root of the tree")
+			}
+		}
+		_, _, err := smt.ProveToDepth([]byte(key), len(fullProof.SideNodes)+1)
+		if err != ErrInvalidDepth {
+			t.Errorf("did not return ErrInvalidDepth for depth below branch: %v", err)
+		}
+	}
+
+	_, _, err := smt.ProveToDepth([]byte("3"), smt.depth()+1)
+	if err != ErrInvalidDepth {
+		t.Errorf("did not return ErrInvalidDepth for depth outside tree: %v", err)
+	}
+}
+
+func TestLightProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	value, proof, root, err := smt.LightProof([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when proving key in empty tree: %v", err)
+	}
+	if !bytes.Equal(value, defaultValue) || !VerifyCompactProof(proof, root, []byte("testKey"), value, sha256.New()) {
+		t.Error("light proof for empty tree failed to verify")
+	}
+
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	for _, key := range []string{"0", "7", "19", "absent"} {
+		value, proof, root, err := smt.LightProof([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		expectedValue, _ := smt.Get([]byte(key))
+		if !bytes.Equal(value, expectedValue) {
+			t.Errorf("light proof returned wrong value for key %s", key)
+		}
+		if !bytes.Equal(root, smt.Root()) {
+			t.Error("light proof returned wrong root")
+		}
+		if !VerifyCompactProof(proof, root, []byte(key), value, sha256.New()) {
+			t.Errorf("light proof failed to verify for key %s", key)
+		}
+	}
+}
+
+func TestValidateProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	proof, _ := smt.ProveUpdatable([]byte("3"))
+	if err := ValidateProof(proof, sha256.New()); err != nil {
+		t.Errorf("returned error when validating valid proof: %v", err)
+	}
+
+	// Case: more side nodes than the depth of the tree.
+	badProof := proof
+	badProof.SiblingData = nil
+	for len(badProof.SideNodes) <= smt.depth() {
+		badProof.SideNodes = append(badProof.SideNodes, smt.th.placeholder())
+	}
+	if err := ValidateProof(badProof, sha256.New()); !errors.Is(err, ErrBadProof) {
+		t.Errorf("expected ErrBadProof when validating proof with extra side nodes, got: %v", err)
+	}
+	if VerifyProof(badProof, smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+		t.Error("proof with extra side nodes verified")
+	}
+
+	// Case: side node of the wrong size.
+	badProof = SparseMerkleProof{SideNodes: append([][]byte{}, proof.SideNodes...)}
+	badProof.SideNodes[len(badProof.SideNodes)-1] = []byte{1}
+	if err := ValidateProof(badProof, sha256.New()); !errors.Is(err, ErrBadProof) {
+		t.Errorf("expected ErrBadProof when validating proof with short side node, got: %v", err)
+	}
+	if VerifyProof(badProof, smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+		t.Error("proof with short side node verified")
+	}
+
+	// Case: sibling data not matching the first side node.
+	badProof = proof
+	badProof.SiblingData = []byte("bad")
+	if err := ValidateProof(badProof, sha256.New()); !errors.Is(err, ErrBadProof) {
+		t.Errorf("expected ErrBadProof when validating proof with bad sibling data, got: %v", err)
+	}
+}
+
+func TestProveAdjacent(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	// Find two keys whose leaves are siblings, and one that is not.
+	var keyA, keyB, other []byte
+	for i := 0; i < 20 && keyA == nil; i++ {
+		key := []byte(strconv.Itoa(i))
+		proof, _ := smt.ProveUpdatable(key)
+		if !smt.th.isLeaf(proof.SiblingData) {
+			other = key
+			continue
+		}
+		keyA = key
+		siblingPath, _, _ := smt.th.parseLeaf(proof.SiblingData)
+		for j := 0; j < 20; j++ {
+			if bytes.Equal(smt.th.path([]byte(strconv.Itoa(j))), siblingPath) {
+				keyB = []byte(strconv.Itoa(j))
+			}
+		}
+	}
+	if keyA == nil || keyB == nil || other == nil {
+		t.Fatal("could not find test keys")
+	}
+
+	proof, err := smt.ProveAdjacent(keyA, keyB)
+	if err != nil {
+		t.Errorf("returned error when proving adjacent keys: %v", err)
+	}
+	if !VerifyAdjacentProof(proof, smt.Root(), keyA, keyA, keyB, keyB, sha256.New()) {
+		t.Error("valid adjacency proof failed to verify")
+	}
+	if VerifyAdjacentProof(proof, smt.Root(), keyA, keyA, keyB, []byte("wrong"), sha256.New()) {
+		t.Error("adjacency proof with wrong value verified")
+	}
+	if VerifyAdjacentProof(proof, smt.Root(), keyA, keyA, other, other, sha256.New()) {
+		t.Error("adjacency proof for wrong key verified")
+	}
+
+	if _, err := smt.ProveAdjacent(keyA, other); !errors.Is(err, ErrNotAdjacent) {
+		t.Errorf("expected ErrNotAdjacent when proving non-adjacent keys, got: %v", err)
+	}
+	if _, err := smt.ProveAdjacent([]byte("absent"), keyB); !errors.Is(err, ErrNotAdjacent) {
+		t.Errorf("expected ErrNotAdjacent when proving absent key, got: %v", err)
+	}
+}
+
+func TestProveForRootWithoutTree(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	var roots [][]byte
+	for i := 0; i < 10; i++ {
+		s := strconv.Itoa(i)
+		root, _ := smt.Update([]byte("testKey"), []byte(s))
+		roots = append(roots, root)
+	}
+
+	for i, root := range roots {
+		s := strconv.Itoa(i)
+		proof, err := ProveForRoot(smn, sha256.New(), root, []byte("testKey"))
+		if err != nil {
+			t.Errorf("returned error when proving key against root: %v", err)
+		}
+		if !VerifyProof(proof, root, []byte("testKey"), []byte(s), sha256.New()) {
+			t.Error("valid proof against historical root failed to verify")
+		}
+	}
+}
+
+func TestFullDepthProofs(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithFullDepthProofs())
+	proof, _ := smt.Prove([]byte("testKey"))
+	if len(proof.SideNodes) != smt.depth() {
+		t.Error("proof against empty tree is not full depth")
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), defaultValue, sha256.New()) {
+		t.Error("full depth proof against empty tree failed to verify")
+	}
+
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	for _, key := range []string{"3", "absent"} {
+		value := []byte(key)
+		if key == "absent" {
+			value = defaultValue
+		}
+		proof, _ := smt.Prove([]byte(key))
+		if len(proof.SideNodes) != smt.depth() {
+			t.Error("proof is not full depth")
+		}
+		if !VerifyProof(proof, smt.Root(), []byte(key), value, sha256.New()) {
+			t.Error("full depth proof failed to verify")
+		}
+		if VerifyProof(proof, smt.Root(), []byte(key), []byte("wrong"), sha256.New()) {
+			t.Error("full depth proof verified with wrong value")
+		}
+
+		compactProof, _ := smt.ProveCompact([]byte(key))
+		if !VerifyCompactProof(compactProof, smt.Root(), []byte(key), value, sha256.New()) {
+			t.Error("full depth compact proof failed to verify")
+		}
+
+		// A padded updatable proof can still be added to a deep subtree.
+		updatableProof, _ := smt.ProveUpdatable([]byte(key))
+		if err := ValidateProof(updatableProof, sha256.New()); err != nil {
+			t.Errorf("full depth updatable proof is invalid: %v", err)
+		}
+		dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), smt.Root())
+		if err := dsmst.AddBranch(updatableProof, []byte(key), value); err != nil {
+			t.Errorf("returned error when adding full depth branch: %v", err)
+		}
+	}
+
+	// Unpadded proofs of the same tree verify too.
+	unpadded := NewSparseMerkleTree(smt.nodes, smt.values, sha256.New())
+	unpadded.SetRoot(smt.Root())
+	proof, _ = unpadded.Prove([]byte("3"))
+	if len(proof.SideNodes) == smt.depth() {
+		t.Error("proof without option is full depth")
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+		t.Error("unpadded proof failed to verify")
+	}
+}
+
+func TestVerifyProofSideNodeSize(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	for _, size := range []int{0, sha256.Size - 1, sha256.Size + 1, 1 << 20} {
+		proof, _ := smt.Prove([]byte("3"))
+		proof.SideNodes[0] = make([]byte, size)
+		if err := ValidateProof(proof, sha256.New()); !errors.Is(err, ErrBadProof) {
+			t.Errorf("expected ErrBadProof for side node of size %d, got: %v", size, err)
+		}
+		if VerifyProof(proof, smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+			t.Errorf("proof with side node of size %d verified", size)
+		}
+
+		compactProof, _ := smt.ProveCompact([]byte("3"))
+		compactProof.SideNodes[0] = make([]byte, size)
+		if _, err := DecompactProof(compactProof, sha256.New()); !errors.Is(err, ErrBadProof) {
+			t.Errorf("expected ErrBadProof when decompacting side node of size %d, got: %v", size, err)
+		}
+		if VerifyCompactProof(compactProof, smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+			t.Errorf("compact proof with side node of size %d verified", size)
+		}
+
+		dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), smt.Root())
+		if err := dsmst.AddBranch(proof, []byte("3"), []byte("3")); !errors.Is(err, ErrBadProof) {
+			t.Errorf("expected ErrBadProof when adding branch with side node of size %d, got: %v", size, err)
+		}
+	}
+}
+
+func TestVerifyProofSiblingData(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+
+	// Find two keys whose paths both go left from the root.
+	var keys [][]byte
+	for i := 0; len(keys) < 2; i++ {
+		key := []byte(strconv.Itoa(i))
+		if getBitAtFromMSB(smt.th.path(key), 0) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		smt.Update(key, key)
+	}
+	proof, _ := smt.ProveUpdatable(keys[0])
+	if !VerifyProof(proof, smt.Root(), keys[0], keys[0], sha256.New()) {
+		t.Error("valid updatable proof failed to verify")
+	}
+
+	// Build a tree with the leaf of the second key misplaced on the right of
+	// the root, as the sibling of the first.
+	leafA, _ := smt.th.digestLeaf(smt.th.path(keys[0]), smt.th.digest(keys[0]))
+	leafB, leafBData := smt.th.digestLeaf(smt.th.path(keys[1]), smt.th.digest(keys[1]))
+	root, _ := smt.th.digestNode(leafA, leafB, 0)
+	proof = SparseMerkleProof{SideNodes: [][]byte{leafB}, SiblingData: leafBData}
+	if VerifyProof(proof, root, keys[0], keys[0], sha256.New()) {
+		t.Error("proof with misplaced sibling leaf verified")
+	}
+	proof.SiblingData = nil
+	if !VerifyProof(proof, root, keys[0], keys[0], sha256.New()) {
+		t.Error("proof without sibling data failed to verify")
+	}
+
+	// Sibling data that hashes to the side node but is not a valid node.
+	badData := append([]byte{1}, make([]byte, 10)...)
+	proof = SparseMerkleProof{SideNodes: [][]byte{smt.th.digestData(badData)}, SiblingData: badData}
+	if err := ValidateProof(proof, sha256.New()); !errors.Is(err, ErrBadProof) {
+		t.Errorf("expected ErrBadProof for malformed sibling data, got: %v", err)
+	}
+}
+
+func TestProveFrom(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	// Without known nodes, the proof is against the root.
+	proof, depth, err := smt.ProveFrom([]byte("3"), nil)
+	if err != nil {
+		t.Fatalf("returned error when proving key: %v", err)
+	}
+	fullProof, _ := smt.Prove([]byte("3"))
+	if depth != 0 || len(proof.SideNodes) != len(fullProof.SideNodes) {
+		t.Error("proof without known nodes is not a full proof")
+	}
+
+	// The client knows the nodes at depths 1 and 2 of the path of "3", and
+	// an unrelated node.
+	known := make(map[string]bool)
+	var nodes [][]byte
+	for d := 0; d <= 2; d++ {
+		_, node, _ := smt.ProveToDepth([]byte("3"), d)
+		nodes = append(nodes, node)
+	}
+	known[string(nodes[1])] = true
+	known[string(nodes[2])] = true
+	known[string(smt.th.placeholder())] = true
+	for _, key := range []string{"3", "absent"} {
+		value := []byte(key)
+		if key == "absent" {
+			value = defaultValue
+		}
+		proof, depth, err := smt.ProveFrom([]byte(key), known)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		fullProof, _ := smt.Prove([]byte(key))
+		if len(proof.SideNodes) != len(fullProof.SideNodes)-depth {
+			t.Error("proof does not start from the known node")
+		}
+		if key == "3" && depth != 2 {
+			t.Errorf("expected proof from depth 2, got: %d", depth)
+		}
+		_, node, _ := smt.ProveToDepth([]byte(key), depth)
+		if depth > 0 && !known[string(node)] {
+			t.Error("proof does not start from a known node")
+		}
+		if !VerifyProofToDepth(proof, node, depth, []byte(key), value, sha256.New()) {
+			t.Error("proof from known node failed to verify")
+		}
+	}
+}
+
+func TestProveMembership(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithTombstones())
+
+	member, proof, err := smt.ProveMembership([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when proving key in empty tree: %v", err)
+	}
+	if member {
+		t.Error("key reported as present in empty tree")
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), defaultValue, sha256.New(), WithTombstones()) {
+		t.Error("non-membership proof in empty tree failed to verify")
+	}
+
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	member, proof, err = smt.ProveMembership([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when proving key: %v", err)
+	}
+	if !member {
+		t.Error("present key reported as absent")
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), []byte("testValue"), sha256.New(), WithTombstones()) {
+		t.Error("membership proof failed to verify")
+	}
+
+	member, proof, err = smt.ProveMembership([]byte("testKey3"))
+	if err != nil {
+		t.Errorf("returned error when proving absent key: %v", err)
+	}
+	if member {
+		t.Error("absent key reported as present")
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("testKey3"), defaultValue, sha256.New(), WithTombstones()) {
+		t.Error("non-membership proof failed to verify")
+	}
+
+	smt.Delete([]byte("testKey"))
+	member, _, err = smt.ProveMembership([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when proving deleted key: %v", err)
+	}
+	if member {
+		t.Error("deleted key reported as present")
+	}
+}
+
+func TestProofDepth(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	keys := [][]byte{[]byte("absent")}
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, key)
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		depth, err := smt.ProofDepth(key)
+		if err != nil {
+			t.Fatalf("returned error when getting proof depth: %v", err)
+		}
+		proof, _ := smt.Prove(key)
+		if depth != len(proof.SideNodes) {
+			t.Errorf("expected proof depth %d, got %d", len(proof.SideNodes), depth)
+		}
+	}
+
+	padded := ImportSparseMerkleTree(smt.nodes, smt.values, sha256.New(), smt.Root(), WithFullDepthProofs())
+	depth, err := padded.ProofDepth([]byte("0"))
+	if err != nil {
+		t.Errorf("returned error when getting proof depth: %v", err)
+	}
+	if depth != padded.depth() {
+		t.Errorf("expected proof depth %d for padded proofs, got %d", padded.depth(), depth)
+	}
+}
+
+func TestProveCommonAncestor(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	depth, digest, proof, err := smt.ProveCommonAncestor([][]byte{[]byte("0")})
+	if err != nil {
+		t.Errorf("returned error when proving ancestor in empty tree: %v", err)
+	}
+	if depth != 0 || !bytes.Equal(digest, smt.Root()) {
+		t.Error("ancestor in empty tree is not the root")
+	}
+	if !VerifyCommonAncestorProof(proof, smt.Root(), [][]byte{[]byte("0")}, depth, digest, sha256.New()) {
+		t.Error("ancestor proof in empty tree failed to verify")
+	}
+
+	// Group keys by the first 4 bits of their paths.
+	groups := make(map[byte][][]byte)
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, key)
+		prefix := smt.Path(key)[0] >> 4
+		groups[prefix] = append(groups[prefix], key)
+	}
+	for _, keys := range groups {
+		depth, digest, proof, err := smt.ProveCommonAncestor(keys)
+		if err != nil {
+			t.Fatalf("returned error when proving ancestor: %v", err)
+		}
+		if depth < 4 {
+			t.Errorf("ancestor of keys sharing 4 bits is at depth %d", depth)
+		}
+		expected, _ := smt.subtreeRoot(smt.root, smt.Path(keys[0]), depth)
+		if !bytes.Equal(digest, expected) {
+			t.Error("ancestor digest does not match node on path")
+		}
+		data, _ := smt.getNode(digest)
+		if smt.th.isLeaf(data) {
+			t.Error("ancestor is a leaf")
+		}
+		if !VerifyCommonAncestorProof(proof, smt.Root(), keys, depth, digest, sha256.New()) {
+			t.Error("ancestor proof failed to verify")
+		}
+		if VerifyCommonAncestorProof(proof, smt.Root(), keys, depth, smt.th.placeholder(), sha256.New()) {
+			t.Error("ancestor proof verified with wrong digest")
+		}
+		if len(proof.SideNodes) > 0 && VerifyCommonAncestorProof(SparseMerkleProof{SideNodes: proof.SideNodes[1:]}, smt.Root(), keys, depth-1, digest, sha256.New()) {
+			t.Error("ancestor proof verified at wrong depth")
+		}
+	}
+
+	if _, _, _, err := smt.ProveCommonAncestor(nil); err != ErrNoKeys {
+		t.Errorf("did not return ErrNoKeys: %v", err)
+	}
+}
+
+// Test that verifying with options that allocate state for a tree, such as
+// WithAbsenceBloom, does not allocate that state.
+func TestVerifyProofTreeOptions(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	proof, _ := smt.Prove([]byte("testKey"))
+	root := smt.Root()
+
+	hasher := sha256.New()
+	verify := func(options []Option) float64 {
+		return testing.AllocsPerRun(10, func() {
+			if !VerifyProof(proof, root, []byte("testKey"), []byte("testValue"), hasher, options...) {
+				t.Fatal("valid proof failed to verify")
+			}
+		})
+	}
+	treeOptions := []Option{WithAbsenceBloom(1 << 20), WithEphemeral(), WithAccessTracking(1000)}
+	if allocs, baseline := verify(treeOptions), verify(nil); allocs > baseline {
+		t.Errorf("verifying with tree options allocated %v times, expected %v", allocs, baseline)
+	}
+}
@@ -0,0 +1,101 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidRootLog is returned when a RootLog's metadata is corrupt.
+var ErrInvalidRootLog = errors.New("invalid root log")
+
+// RootEntry is a root recorded in a RootLog.
+type RootEntry struct {
+	// Seq is the index of the root in the log, starting from 0.
+	Seq uint64
+	// Root is the root digest.
+	Root []byte
+}
+
+// RootLog is an append-only log of roots kept in a MapStore, under keys
+// derived from a metadata key, so that a caller keeping the history of a tree
+// can enumerate its roots.
+type RootLog struct {
+	store MapStore
+	key   []byte
+}
+
+// NewRootLog creates a RootLog stored in a MapStore under keys derived from
+// the given metadata key, which must not collide with other keys of the
+// store.
+func NewRootLog(store MapStore, key []byte) *RootLog {
+	return &RootLog{store: store, key: key}
+}
+
+func (rl *RootLog) entryKey(seq uint64) []byte {
+	key := make([]byte, len(rl.key)+8)
+	copy(key, rl.key)
+	binary.BigEndian.PutUint64(key[len(rl.key):], seq)
+	return key
+}
+
+// Len returns the number of roots in the log.
+func (rl *RootLog) Len() (uint64, error) {
+	data, err := rl.store.Get(rl.key)
+	if err != nil {
+		var invalidKeyError *InvalidKeyError
+		if errors.As(err, &invalidKeyError) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, ErrInvalidRootLog
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// Append adds a root to the end of the log, and returns its entry.
+func (rl *RootLog) Append(root []byte) (RootEntry, error) {
+	seq, err := rl.Len()
+	if err != nil {
+		return RootEntry{}, err
+	}
+	if err := rl.store.Set(rl.entryKey(seq), root); err != nil {
+		return RootEntry{}, err
+	}
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, seq+1)
+	if err := rl.store.Set(rl.key, length); err != nil {
+		return RootEntry{}, err
+	}
+	return RootEntry{Seq: seq, Root: root}, nil
+}
+
+// logRoot appends the root of the tree to its root log, if any, if it changed
+// from oldRoot. It is called once the root is set, so that a failure to log
+// the root does not leave the tree at a root whose orphans were deleted.
+func (smt *SparseMerkleTree) logRoot(oldRoot []byte) error {
+	if smt.rootLog == nil || bytes.Equal(smt.root, oldRoot) {
+		return nil
+	}
+	_, err := smt.rootLog.Append(smt.root)
+	return err
+}
+
+// Roots returns all the roots in the log, in the order they were appended.
+func (rl *RootLog) Roots() ([]RootEntry, error) {
+	length, err := rl.Len()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]RootEntry, 0, length)
+	for seq := uint64(0); seq < length; seq++ {
+		root, err := rl.store.Get(rl.entryKey(seq))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, RootEntry{Seq: seq, Root: root})
+	}
+	return entries, nil
+}
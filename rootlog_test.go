@@ -0,0 +1,76 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestRootLog(t *testing.T) {
+	rl := NewRootLog(NewSimpleMap(), []byte("roots"))
+	roots, err := rl.Roots()
+	if err != nil {
+		t.Errorf("returned error when reading empty root log: %v", err)
+	}
+	if len(roots) != 0 {
+		t.Error("empty root log returned roots")
+	}
+
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootLog(rl))
+	var expected [][]byte
+	for i := 0; i < 5; i++ {
+		s := strconv.Itoa(i)
+		root, _ := smt.Update([]byte(s), []byte(s))
+		expected = append(expected, root)
+	}
+	// Updates that do not change the root are not logged.
+	smt.Update([]byte("0"), []byte("0"))
+	root, _ := smt.Delete([]byte("0"))
+	expected = append(expected, root)
+
+	roots, err = rl.Roots()
+	if err != nil {
+		t.Errorf("returned error when reading root log: %v", err)
+	}
+	if len(roots) != len(expected) {
+		t.Fatalf("expected %d roots, got: %d", len(expected), len(roots))
+	}
+	for i, entry := range roots {
+		if entry.Seq != uint64(i) || !bytes.Equal(entry.Root, expected[i]) {
+			t.Errorf("unexpected root log entry %d", i)
+		}
+	}
+}
+
+// Test that a failure to append to the root log leaves the tree usable.
+func TestRootLogAppendFailure(t *testing.T) {
+	logStore := &failOnceMapStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(),
+		WithTombstones(), WithRootLog(NewRootLog(logStore, []byte("roots"))))
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+
+	for _, op := range []func() error{
+		func() error { _, err := smt.Update([]byte("3"), []byte("testValue2")); return err },
+		func() error { return smt.UpdateMany([][]byte{[]byte("4")}, []byte("testValue2")) },
+		func() error { return smt.DeletePath(smt.Path([]byte("5"))) },
+		func() error { _, err := smt.Purge([]byte("5")); return err },
+	} {
+		logStore.failIn = 1
+		if err := op(); err != errFailOnce {
+			t.Fatalf("did not return error of root log store: %v", err)
+		}
+		if err := smt.ValidateAgainstStore(); err != nil {
+			t.Fatalf("tree is inconsistent with the store after failed append: %v", err)
+		}
+		proof, err := smt.Prove([]byte("0"))
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), []byte("0"), []byte("testValue"), sha256.New(), WithTombstones()) {
+			t.Error("valid proof failed to verify after failed append")
+		}
+	}
+}
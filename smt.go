@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"errors"
 	"hash"
+	"io"
+	"sort"
 )
 
 const (
@@ -15,134 +17,831 @@ var defaultValue = []byte{}
 
 var errKeyAlreadyEmpty = errors.New("key already empty")
 
+// ErrInvalidPath is returned when a path does not have the size of the paths
+// of the tree.
+var ErrInvalidPath = errors.New("invalid path")
+
+// ErrInvalidDepth is returned when a depth is outside of the tree, or below
+// the end of the branch it refers to.
+var ErrInvalidDepth = errors.New("invalid depth")
+
+// ErrNoKeys is returned when an operation on a set of keys is given none.
+var ErrNoKeys = errors.New("no keys")
+
+// ErrNoOrphanTracking is returned when deleting a key from a tree that does
+// not track orphaned nodes.
+var ErrNoOrphanTracking = errors.New("cannot delete without orphan tracking")
+
+// ErrCycleDetected is returned when a traversal of the nodes MapStore goes
+// deeper than the tree, which can only happen if nodes reference themselves
+// or their ancestors, i.e. the store is corrupt.
+var ErrCycleDetected = errors.New("cycle detected in tree")
+
+// ErrUnexpectedInnerNode is returned when a traversal of the nodes MapStore
+// along a path finds an inner node where the path is exhausted, and there must
+// be a leaf or nothing, i.e. the store is corrupt.
+var ErrUnexpectedInnerNode = errors.New("unexpected inner node at maximum depth")
+
+// innerNodeAtDepthError returns the error for a traversal that found an inner
+// node at the depth of the tree, having gone through the given nodes.
+func innerNodeAtDepthError(pathNodes [][]byte) error {
+	seen := make(map[string]bool, len(pathNodes))
+	for _, node := range pathNodes {
+		if seen[string(node)] {
+			return ErrCycleDetected
+		}
+		seen[string(node)] = true
+	}
+	return ErrUnexpectedInnerNode
+}
+
+// ErrNodeCorrupt is returned when a node read from the nodes MapStore does not
+// hash to its digest.
+var ErrNodeCorrupt = errors.New("node data does not match digest")
+
+// ErrEmptyKey is returned when using an empty key with a tree created with
+// WithRejectEmptyKey.
+var ErrEmptyKey = errors.New("empty key")
+
+// ErrNotAdjacent is returned when proving that the leaves of two keys are
+// siblings, and they are not.
+var ErrNotAdjacent = errors.New("keys are not adjacent")
+
 // SparseMerkleTree is a Sparse Merkle tree.
 type SparseMerkleTree struct {
 	th            treeHasher
 	nodes, values MapStore
 	root          []byte
+
+	orphanCallback   func(digest []byte)
+	persistCallback  func(digest, data []byte)
+	noOrphanTracking bool
+	emptyRootNil     bool
+	batch            BatchWriter
+	verifyOnRead     bool
+	accessLog        *accessLog
+	closed           bool
+	rootLog          *RootLog
+	copyValues       bool
+	tombstones       bool
+	versionedLeaves  bool
+	version          uint64
+	rejectDuplicates bool
+	fullDepthProofs  bool
+	wal              io.Writer
+	undoLimit        int
+	undoStack        []undoEntry
+	redoStack        []undoEntry
+	rejectEmptyKey   bool
+	absenceBloom     *absenceBloom
+	saveWorkers      int
+	logger           func(event TraceEvent)
+	pendingOrphans   map[string]struct{}
+	newOrphans       map[string]struct{}
+	tracing          bool
+	traceReads       int
+	traceDepth       int
+	// The state allocated by options once they are all applied, which
+	// verifiers applying the options do not need.
+	accessLogSize int
+	bloomBits     int
+	ephemeral     bool
+	// maxDepth is the depth of the deepest leaf of the tree at maxDepthRoot.
+	maxDepth     int
+	maxDepthRoot []byte
 }
 
 // NewSparseMerkleTree creates a new Sparse Merkle tree on an empty MapStore.
+// The hasher's digests must always be hasher.Size() bytes long, as they are
+// used as the paths of keys in the tree; updates return ErrInvalidPath
+// otherwise.
 func NewSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, options ...Option) *SparseMerkleTree {
 	smt := SparseMerkleTree{
 		th:     *newTreeHasher(hasher),
 		nodes:  nodes,
 		values: values,
 	}
-
-	for _, option := range options {
-		option(&smt)
-	}
+	smt.applyOptions(options)
 
 	smt.SetRoot(smt.th.placeholder())
+	smt.maxDepthRoot = smt.th.placeholder()
 
 	return &smt
 }
 
 // ImportSparseMerkleTree imports a Sparse Merkle tree from a non-empty MapStore.
-func ImportSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, root []byte) *SparseMerkleTree {
+// Operations on the tree return ErrHasherMismatch if the root does not have
+// the size of the digests of the hasher.
+func ImportSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) *SparseMerkleTree {
 	smt := SparseMerkleTree{
 		th:     *newTreeHasher(hasher),
 		nodes:  nodes,
 		values: values,
-		root:   root,
 	}
+	smt.applyOptions(options)
+
+	smt.SetRoot(root)
+
 	return &smt
 }
 
-// Root gets the root of the tree.
+// applyOptions applies options to a new tree, and then allocates the state
+// they call for. Verifiers apply the options alone, as they only need their
+// effect on the treeHasher.
+func (smt *SparseMerkleTree) applyOptions(options []Option) {
+	for _, option := range options {
+		option(smt)
+	}
+	if smt.ephemeral {
+		smt.nodes, smt.values = NewSimpleMap(), NewSimpleMap()
+	}
+	if smt.accessLogSize > 0 {
+		smt.accessLog = newAccessLog(smt.accessLogSize)
+	}
+	if smt.bloomBits > 0 {
+		smt.absenceBloom = newAbsenceBloom(smt.bloomBits)
+		smt.absenceBloom.root = smt.th.placeholder()
+	}
+}
+
+// Root gets the root of the tree. The root of an empty tree is the
+// placeholder, unless the tree was created with WithEmptyRootNil.
 func (smt *SparseMerkleTree) Root() []byte {
+	if smt.emptyRootNil && bytes.Equal(smt.root, smt.th.placeholder()) {
+		return nil
+	}
 	return smt.root
 }
 
-// SetRoot sets the root of the tree.
+// SetRoot sets the root of the tree. A nil root is the root of an empty tree.
 func (smt *SparseMerkleTree) SetRoot(root []byte) {
+	if len(root) == 0 {
+		root = smt.th.placeholder()
+	}
 	smt.root = root
 }
 
+// ReplaceRoot sets the root of the tree to a root whose nodes are already in
+// the nodes MapStore, e.g. computed by another instance, after checking that
+// the root node is present. Unlike SetRoot, it also clears the operations
+// recorded by WithUndo, which do not apply to the new root. A nil root is the
+// root of an empty tree.
+func (smt *SparseMerkleTree) ReplaceRoot(root []byte) error {
+	if smt.closed {
+		return ErrClosed
+	}
+	if len(root) != 0 && !bytes.Equal(root, smt.th.placeholder()) {
+		if _, err := smt.getNode(root); err != nil {
+			return err
+		}
+	}
+	smt.SetRoot(root)
+	smt.undoStack, smt.redoStack = nil, nil
+	return nil
+}
+
+// PendingOrphans returns the digests of the nodes orphaned by updates of a tree
+// created with WithNoOrphanTracking, which are left in the nodes MapStore, in
+// sorted order. Nodes that are written again by later updates are not
+// included, so the nodes can be deleted from the store, e.g. by a background
+// pruner, as long as no other tree references them. The digests are kept in
+// memory for the life of the tree.
+func (smt *SparseMerkleTree) PendingOrphans() [][]byte {
+	digests := make([]string, 0, len(smt.pendingOrphans))
+	for digest := range smt.pendingOrphans {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	orphans := make([][]byte, len(digests))
+	for i, digest := range digests {
+		orphans[i] = []byte(digest)
+	}
+	return orphans
+}
+
+// RootNode returns the serialized data of the root node of the tree, which
+// hashes to the root, or nil if the tree is empty.
+func (smt *SparseMerkleTree) RootNode() ([]byte, error) {
+	if bytes.Equal(smt.root, smt.th.placeholder()) {
+		return nil, nil
+	}
+	return smt.getNode(smt.root)
+}
+
+// Path returns the path of a key in the tree: the hash of the key, truncated
+// if the tree was created with WithPathLength. The leaf of the key is on the
+// branch following the bits of the path from the most significant, and values
+// are stored in the values MapStore under it.
+func (smt *SparseMerkleTree) Path(key []byte) []byte {
+	return smt.th.path(key)
+}
+
+// PathBits returns the first depth bits of the path of a key, as the
+// left (false) or right (true) decisions taken to descend the tree from the
+// root to the key's leaf. depth is capped at the depth of the tree.
+func (smt *SparseMerkleTree) PathBits(key []byte, depth int) []bool {
+	if depth > smt.depth() {
+		depth = smt.depth()
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	path := smt.th.path(key)
+	bits := make([]bool, depth)
+	for i := range bits {
+		bits[i] = getBitAtFromMSB(path, i) == right
+	}
+	return bits
+}
+
+// checkKey returns ErrEmptyKey for an empty key if the tree rejects them.
+func (smt *SparseMerkleTree) checkKey(key []byte) error {
+	if smt.rejectEmptyKey && len(key) == 0 {
+		return ErrEmptyKey
+	}
+	return nil
+}
+
+// checkDelete returns ErrNoOrphanTracking if setting a key to the value deletes
+// it, and the tree cannot delete keys. It is checked before an operation is
+// written to the write-ahead log, so that the log only records operations
+// that can be replayed.
+func (smt *SparseMerkleTree) checkDelete(value []byte) error {
+	if smt.th.isDefault(value) && smt.noOrphanTracking && !smt.tombstones {
+		return ErrNoOrphanTracking
+	}
+	return nil
+}
+
 func (smt *SparseMerkleTree) depth() int {
 	return smt.th.pathSize() * 8
 }
 
-// Get gets the value of a key from the tree.
+// getNode reads a node from the nodes MapStore, checking that its data
+// matches its digest if verifyOnRead is set.
+func (smt *SparseMerkleTree) getNode(digest []byte) ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	if len(digest) != smt.th.hasher.Size() {
+		// Digests read from nodes have the right size, so this is a root
+		// given by the caller, e.g. of a tree created with another hasher.
+		return nil, ErrHasherMismatch
+	}
+	if smt.tracing {
+		smt.traceReads++
+	}
+	data, err := smt.nodes.Get(digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.th.checkFormatVersion(data); err != nil {
+		return nil, err
+	}
+	if smt.verifyOnRead && !bytes.Equal(smt.th.digestData(data), digest) {
+		return nil, ErrNodeCorrupt
+	}
+	return data, nil
+}
+
+// setNode writes a node to the nodes MapStore, or to the batch if any.
+func (smt *SparseMerkleTree) setNode(digest, data []byte) error {
+	var err error
+	if smt.batch != nil {
+		err = smt.batch.Set(digest, data)
+	} else {
+		err = smt.nodes.Set(digest, data)
+	}
+	if err != nil {
+		return err
+	}
+	// A node written again is no longer orphaned.
+	delete(smt.pendingOrphans, string(digest))
+	delete(smt.newOrphans, string(digest))
+	if smt.persistCallback != nil {
+		smt.persistCallback(digest, data)
+	}
+	return nil
+}
+
+// deleteNode removes an orphaned node from the nodes MapStore, or adds its
+// deletion to the batch if any.
+func (smt *SparseMerkleTree) deleteNode(digest []byte) error {
+	if smt.noOrphanTracking {
+		if !bytes.Equal(digest, smt.th.placeholder()) {
+			if smt.newOrphans == nil {
+				smt.newOrphans = make(map[string]struct{})
+			}
+			smt.newOrphans[string(digest)] = struct{}{}
+		}
+		return nil
+	}
+	var err error
+	if smt.batch != nil {
+		err = smt.batch.Delete(digest)
+	} else {
+		err = smt.nodes.Delete(digest)
+	}
+	if err != nil {
+		return err
+	}
+	if smt.orphanCallback != nil {
+		smt.orphanCallback(digest)
+	}
+	return nil
+}
+
+// commitNodes commits the batch, if any, at the end of an operation.
+func (smt *SparseMerkleTree) commitNodes() error {
+	if len(smt.newOrphans) > 0 {
+		if smt.pendingOrphans == nil {
+			smt.pendingOrphans = make(map[string]struct{})
+		}
+		for digest := range smt.newOrphans {
+			smt.pendingOrphans[digest] = struct{}{}
+		}
+		smt.newOrphans = nil
+	}
+	if smt.batch == nil {
+		return nil
+	}
+	return smt.batch.Commit()
+}
+
+// discardNodes discards the batch, if any, and the orphans recorded by an
+// operation that failed, whose nodes are still part of the tree.
+func (smt *SparseMerkleTree) discardNodes() {
+	smt.newOrphans = nil
+	if smt.batch != nil {
+		smt.batch.Discard()
+	}
+}
+
+// Get gets the value of a key from the tree. The value may be the slice held
+// by the values MapStore, so it must not be modified, unless the tree was
+// created with WithCopyValues.
 func (smt *SparseMerkleTree) Get(key []byte) ([]byte, error) {
+	value, _, err := smt.GetVersioned(key)
+	return value, err
+}
+
+// GetVersioned gets the value of a key from the tree, along with the version
+// at which it was set if the tree was created with WithVersionedLeaves, or 0
+// otherwise. The version of an absent key is 0.
+func (smt *SparseMerkleTree) GetVersioned(key []byte) ([]byte, uint64, error) {
+	if smt.logger == nil || smt.tracing {
+		return smt.getVersioned(key)
+	}
+	smt.startTrace()
+	value, version, err := smt.getVersioned(key)
+	smt.endTrace(TraceGet, key, err)
+	return value, version, err
+}
+
+func (smt *SparseMerkleTree) getVersioned(key []byte) ([]byte, uint64, error) {
+	if smt.closed {
+		return nil, 0, ErrClosed
+	}
+	if err := smt.checkKey(key); err != nil {
+		return nil, 0, err
+	}
+	if smt.accessLog != nil {
+		smt.accessLog.record(key)
+	}
+	return smt.getPath(smt.th.path(key))
+}
+
+// getPath gets the value at a path, and the version at which it was set.
+func (smt *SparseMerkleTree) getPath(path []byte) ([]byte, uint64, error) {
 	// Get tree's root
-	root := smt.Root()
+	root := smt.root
 
 	if bytes.Equal(root, smt.th.placeholder()) {
 		// The tree is empty, return the default value.
-		return defaultValue, nil
+		return smt.th.copyDefaultValue(), 0, nil
+	}
+	if len(root) != smt.th.hasher.Size() {
+		// Values are read by path without walking the tree, so the root
+		// is not otherwise checked.
+		return nil, 0, ErrHasherMismatch
 	}
 
-	path := smt.th.path(key)
+	if smt.bloomValid() && !smt.absenceBloom.mayContain(path) {
+		return smt.th.copyDefaultValue(), 0, nil
+	}
+	if smt.tracing {
+		smt.traceReads++
+	}
 	value, err := smt.values.Get(path)
 
 	if err != nil {
 		var invalidKeyError *InvalidKeyError
 
 		if errors.As(err, &invalidKeyError) {
+			if smt.tombstones {
+				if err := smt.checkTombstone(path); err != nil {
+					return nil, 0, err
+				}
+			}
 			// If key isn't found, return default value
-			return defaultValue, nil
+			return smt.th.copyDefaultValue(), 0, nil
 		} else {
 			// Otherwise percolate up any other error
-			return nil, err
+			return nil, 0, err
 		}
 	}
+	var version uint64
+	if smt.versionedLeaves {
+		value, version, err = splitVersion(value)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if smt.copyValues {
+		value = append([]byte(nil), value...)
+	}
+	return value, version, nil
+}
+
+// GetPathValue gets the value at a path, e.g. as returned by Path, rather than
+// the value of a key, so that callers that keep the paths of large keys do not
+// have to hash them again. ErrInvalidPath is returned if the path does not
+// have the size of the paths of the tree.
+func (smt *SparseMerkleTree) GetPathValue(path []byte) ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	if len(path) != smt.th.pathSize() {
+		return nil, ErrInvalidPath
+	}
+	value, _, err := smt.getPath(path)
+	return value, err
+}
+
+// GetOrDefault gets the value of a key from the tree, or fallback if the key is
+// absent, i.e. its value is the default value, or it was deleted from a tree
+// created with WithTombstones. Only errors reading the tree are returned.
+func (smt *SparseMerkleTree) GetOrDefault(key []byte, fallback []byte) ([]byte, error) {
+	value, err := smt.Get(key)
+	if err == ErrKeyDeleted {
+		return fallback, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(value, smt.th.defaultValue) {
+		return fallback, nil
+	}
 	return value, nil
 }
 
+// GetBatch gets the values of many keys from the tree, in the order of the
+// keys. The value of each absent key is the default value.
+func (smt *SparseMerkleTree) GetBatch(keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := smt.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
 // Has returns true if the value at the given key is non-default, false
 // otherwise.
 func (smt *SparseMerkleTree) Has(key []byte) (bool, error) {
 	val, err := smt.Get(key)
-	return !bytes.Equal(defaultValue, val), err
+	return !bytes.Equal(smt.th.defaultValue, val), err
 }
 
 // Update sets a new value for a key in the tree, and sets and returns the new root of the tree.
 func (smt *SparseMerkleTree) Update(key []byte, value []byte) ([]byte, error) {
-	newRoot, err := smt.UpdateForRoot(key, value, smt.Root())
+	if smt.logger == nil || smt.tracing {
+		return smt.update(key, value)
+	}
+	smt.startTrace()
+	root, err := smt.update(key, value)
+	op := TraceUpdate
+	if smt.th.isDefault(value) {
+		op = TraceDelete
+	}
+	smt.endTrace(op, key, err)
+	return root, err
+}
+
+func (smt *SparseMerkleTree) update(key []byte, value []byte) ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	if err := smt.checkKey(key); err != nil {
+		return nil, err
+	}
+	if err := smt.checkDelete(value); err != nil {
+		return nil, err
+	}
+	var oldValue []byte
+	var oldKeyVersion uint64
+	oldVersion := smt.version
+	if smt.undoLimit > 0 {
+		// The value is read by path, so that the read is not recorded by
+		// WithAccessTracking.
+		var err error
+		oldValue, oldKeyVersion, err = smt.getPath(smt.th.path(key))
+		if err == ErrKeyDeleted {
+			oldValue, oldKeyVersion, err = smt.th.defaultValue, 0, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := smt.writeWAL(key, value); err != nil {
+		return nil, err
+	}
+	newRoot, err := smt.UpdateForRoot(key, value, smt.root)
 	if err != nil {
 		return nil, err
 	}
+	// The nodes of the new root are committed, and the orphans of the old
+	// one deleted, so the root is set even if it cannot be logged.
+	oldRoot := smt.root
+	smt.updateBloom(key, value, newRoot)
 	smt.SetRoot(newRoot)
-	return newRoot, nil
+	if smt.undoLimit > 0 {
+		smt.recordUndo(undoEntry{
+			key:           key,
+			oldValue:      oldValue,
+			newValue:      value,
+			oldKeyVersion: oldKeyVersion,
+			oldVersion:    oldVersion,
+			newVersion:    smt.version,
+		})
+	}
+	if err := smt.logRoot(oldRoot); err != nil {
+		return nil, err
+	}
+	return smt.Root(), nil
+}
+
+// Swap sets a new value for a key in the tree, like Update, and returns the
+// previous value of the key, and whether the key was present. The previous
+// value of an absent key, including a key deleted from a tree created with
+// WithTombstones, is the default value.
+func (smt *SparseMerkleTree) Swap(key []byte, value []byte) ([]byte, bool, error) {
+	if smt.closed {
+		return nil, false, ErrClosed
+	}
+	if err := smt.checkKey(key); err != nil {
+		return nil, false, err
+	}
+	previous, _, err := smt.getPath(smt.th.path(key))
+	if err == ErrKeyDeleted {
+		previous, err = smt.th.copyDefaultValue(), nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	existed := !bytes.Equal(previous, smt.th.defaultValue)
+	if existed {
+		// The slice may be held by the values MapStore, which the update
+		// writes to.
+		previous = append([]byte(nil), previous...)
+	}
+	if _, err := smt.Update(key, value); err != nil {
+		return nil, false, err
+	}
+	return previous, existed, nil
+}
+
+// UpdateMany sets the same value for many keys, hashing the value only once,
+// and sets the new root of the tree. The root is the same as after updating
+// each key in turn. For a tree with versioned leaves, each key is set at its
+// own version, so the value is hashed for each key.
+//
+// The keys are updated in turn, each one removing the orphaned nodes of the
+// previous root, so the call is not atomic: if updating a key fails, e.g. on
+// an error of a store, the keys before it remain updated. The root of the
+// tree, the root log and the write-ahead log then reflect the keys updated.
+func (smt *SparseMerkleTree) UpdateMany(keys [][]byte, value []byte) error {
+	if smt.closed {
+		return ErrClosed
+	}
+	for _, key := range keys {
+		if err := smt.checkKey(key); err != nil {
+			return err
+		}
+	}
+	if err := smt.checkDelete(value); err != nil {
+		// Checked before any key is deleted.
+		return err
+	}
+	var valueHash []byte
+	if !smt.th.isDefault(value) && !smt.versionedLeaves {
+		valueHash = smt.th.digest(value)
+	}
+	oldRoot := smt.root
+	err := smt.updateMany(keys, value, valueHash)
+	if logErr := smt.logRoot(oldRoot); err == nil {
+		err = logErr
+	}
+	// The previous values of the keys are not recorded.
+	smt.undoStack, smt.redoStack = nil, nil
+	return err
+}
+
+// updateMany sets the same value, whose hash is given unless the tree has
+// versioned leaves, for many keys, setting the root of the tree after each.
+func (smt *SparseMerkleTree) updateMany(keys [][]byte, value []byte, valueHash []byte) error {
+	for _, key := range keys {
+		if err := smt.writeWAL(key, value); err != nil {
+			return err
+		}
+		keyValue, keyValueHash := value, valueHash
+		if !smt.th.isDefault(value) && smt.versionedLeaves {
+			keyValue = smt.versionValue(value)
+			keyValueHash = smt.th.digest(keyValue)
+		}
+		newRoot, err := smt.updateForRoot(key, keyValue, keyValueHash, smt.root)
+		if err == nil {
+			// Later keys are updated from the nodes written for earlier ones.
+			err = smt.commitNodes()
+		}
+		if err != nil {
+			smt.discardNodes()
+			return err
+		}
+		if keyValueHash != nil {
+			smt.advanceVersion()
+		}
+		smt.updateBloom(key, keyValue, newRoot)
+		smt.root = newRoot
+	}
+	return nil
+}
+
+// UpdatePreview returns the root the tree would have after setting a new
+// value for a key, without modifying the tree or its stores.
+func (smt *SparseMerkleTree) UpdatePreview(key []byte, value []byte) ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	// Only the settings that affect the root are copied, so that no state of
+	// the tree, such as its access log or callbacks, is touched.
+	preview := SparseMerkleTree{
+		th:               smt.th,
+		nodes:            newOverlayMapStore(smt.nodes),
+		values:           newOverlayMapStore(smt.values),
+		root:             smt.root,
+		noOrphanTracking: smt.noOrphanTracking,
+		emptyRootNil:     smt.emptyRootNil,
+		verifyOnRead:     smt.verifyOnRead,
+		tombstones:       smt.tombstones,
+		versionedLeaves:  smt.versionedLeaves,
+		version:          smt.version,
+		rejectEmptyKey:   smt.rejectEmptyKey,
+	}
+	return preview.Update(key, value)
 }
 
 // Delete deletes a value from tree. It returns the new root of the tree.
 func (smt *SparseMerkleTree) Delete(key []byte) ([]byte, error) {
-	return smt.Update(key, defaultValue)
+	return smt.Update(key, smt.th.defaultValue)
 }
 
 // UpdateForRoot sets a new value for a key in the tree at a specific root, and returns the new root.
 func (smt *SparseMerkleTree) UpdateForRoot(key []byte, value []byte, root []byte) ([]byte, error) {
-	path := smt.th.path(key)
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	if err := smt.checkKey(key); err != nil {
+		return nil, err
+	}
+	var valueHash []byte
+	if !smt.th.isDefault(value) {
+		value = smt.versionValue(value)
+		valueHash = smt.th.digest(value)
+	}
+	newRoot, err := smt.updateForRoot(key, value, valueHash, root)
+	if err != nil {
+		smt.discardNodes()
+		return nil, err
+	}
+	if err := smt.commitNodes(); err != nil {
+		smt.discardNodes()
+		return nil, err
+	}
+	if valueHash != nil {
+		smt.advanceVersion()
+	}
+	return newRoot, nil
+}
+
+// updateForRoot sets a new value for a key, whose hash is given, at a
+// specific root, and returns the new root, without committing the batch.
+func (smt *SparseMerkleTree) updateForRoot(key []byte, value []byte, valueHash []byte, root []byte) ([]byte, error) {
+	if smt.accessLog != nil {
+		smt.accessLog.record(key)
+	}
+	return smt.updatePathForRoot(smt.th.path(key), value, valueHash, root)
+}
+
+// updatePathForRoot is like updateForRoot, but for a path rather than a key.
+func (smt *SparseMerkleTree) updatePathForRoot(path []byte, value []byte, valueHash []byte, root []byte) ([]byte, error) {
+	if len(path) != smt.th.pathSize() {
+		// The tree is traversed bit by bit along the path, so every path must
+		// be exactly as long as the tree is deep.
+		return nil, ErrInvalidPath
+	}
 	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(path, root, false)
 	if err != nil {
 		return nil, err
 	}
+	smt.traceDepth = len(sideNodes)
 
 	var newRoot []byte
-	if bytes.Equal(value, defaultValue) {
+	if valueHash == nil && smt.tombstones {
+		// Delete operation, replacing the key's leaf with a tombstone.
+		if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+			return root, nil
+		}
+		actualPath, _, err := smt.th.parseLeaf(oldLeafData)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(path, actualPath) {
+			return root, nil
+		}
+		newRoot, err = smt.updateWithSideNodes(path, nil, smt.th.placeholder(), sideNodes, pathNodes, oldLeafData)
+		if err != nil {
+			return nil, err
+		}
+	} else if valueHash == nil {
 		// Delete operation.
+		if smt.noOrphanTracking {
+			return nil, ErrNoOrphanTracking
+		}
 		newRoot, err = smt.deleteWithSideNodes(path, sideNodes, pathNodes, oldLeafData)
 		if errors.Is(err, errKeyAlreadyEmpty) {
 			// This key is already empty; return the old root.
 			return root, nil
 		}
+		if err != nil {
+			return nil, err
+		}
 		if err := smt.values.Delete(path); err != nil {
 			return nil, err
 		}
 
 	} else {
 		// Insert or update operation.
-		newRoot, err = smt.updateWithSideNodes(path, value, sideNodes, pathNodes, oldLeafData)
+		newRoot, err = smt.updateWithSideNodes(path, value, valueHash, sideNodes, pathNodes, oldLeafData)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return newRoot, err
+	return newRoot, nil
+}
+
+// DeletePath deletes the value at a path, e.g. as returned by Path, rather than
+// the value of a key, and sets the new root of the tree. ErrInvalidPath is
+// returned if the path does not have the size of the paths of the tree. Since
+// the key is not known, it is not recorded by WithAccessTracking, and the
+// operations recorded by WithUndo are cleared.
+func (smt *SparseMerkleTree) DeletePath(path []byte) error {
+	if smt.closed {
+		return ErrClosed
+	}
+	if len(path) != smt.th.pathSize() {
+		return ErrInvalidPath
+	}
+	if err := smt.checkDelete(smt.th.defaultValue); err != nil {
+		return err
+	}
+	if err := smt.writeWALDeletePath(path); err != nil {
+		return err
+	}
+	newRoot, err := smt.updatePathForRoot(path, smt.th.defaultValue, nil, smt.root)
+	if err != nil {
+		smt.discardNodes()
+		return err
+	}
+	if err := smt.commitNodes(); err != nil {
+		smt.discardNodes()
+		return err
+	}
+	oldRoot := smt.root
+	if smt.bloomValid() {
+		smt.absenceBloom.root = newRoot
+	}
+	smt.SetRoot(newRoot)
+	smt.undoStack, smt.redoStack = nil, nil
+	return smt.logRoot(oldRoot)
 }
 
 // DeleteForRoot deletes a value from tree at a specific root. It returns the new root of the tree.
 func (smt *SparseMerkleTree) DeleteForRoot(key, root []byte) ([]byte, error) {
-	return smt.UpdateForRoot(key, defaultValue, root)
+	return smt.UpdateForRoot(key, smt.th.defaultValue, root)
 }
 
 func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
@@ -150,14 +849,17 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 		// This key is already empty as it is a placeholder; return an error.
 		return nil, errKeyAlreadyEmpty
 	}
-	actualPath, _ := smt.th.parseLeaf(oldLeafData)
+	actualPath, _, err := smt.th.parseLeaf(oldLeafData)
+	if err != nil {
+		return nil, err
+	}
 	if !bytes.Equal(path, actualPath) {
 		// This key is already empty as a different key was found its place; return an error.
 		return nil, errKeyAlreadyEmpty
 	}
 	// All nodes above the deleted leaf are now orphaned
 	for _, node := range pathNodes {
-		if err := smt.nodes.Delete(node); err != nil {
+		if err := smt.deleteNode(node); err != nil {
 			return nil, err
 		}
 	}
@@ -166,7 +868,7 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 	nonPlaceholderReached := false
 	for i, sideNode := range sideNodes {
 		if currentData == nil {
-			sideNodeValue, err := smt.nodes.Get(sideNode)
+			sideNodeValue, err := smt.getNode(sideNode)
 			if err != nil {
 				return nil, err
 			}
@@ -193,12 +895,13 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 			nonPlaceholderReached = true
 		}
 
-		if getBitAtFromMSB(path, len(sideNodes)-1-i) == right {
-			currentHash, currentData = smt.th.digestNode(sideNode, currentData)
+		nodeDepth := len(sideNodes) - 1 - i
+		if getBitAtFromMSB(path, nodeDepth) == right {
+			currentHash, currentData = smt.th.digestNode(sideNode, currentData, nodeDepth)
 		} else {
-			currentHash, currentData = smt.th.digestNode(currentData, sideNode)
+			currentHash, currentData = smt.th.digestNode(currentData, sideNode, nodeDepth)
 		}
-		if err := smt.nodes.Set(currentHash, currentData); err != nil {
+		if err := smt.setNode(currentHash, currentData); err != nil {
 			return nil, err
 		}
 		currentData = currentHash
@@ -211,13 +914,11 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 	return currentHash, nil
 }
 
-func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
-	valueHash := smt.th.digest(value)
-	currentHash, currentData := smt.th.digestLeaf(path, valueHash)
-	if err := smt.nodes.Set(currentHash, currentData); err != nil {
+func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, valueHash []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
+	if err := smt.th.checkLeaf(path, valueHash); err != nil {
 		return nil, err
 	}
-	currentData = currentHash
+	currentHash, currentData := smt.th.digestLeaf(path, valueHash)
 
 	// If the leaf node that sibling nodes lead to has a different actual path
 	// than the leaf node being updated, we need to create an intermediate node
@@ -231,38 +932,54 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, side
 		commonPrefixCount = smt.depth()
 	} else {
 		var actualPath []byte
-		actualPath, oldValueHash = smt.th.parseLeaf(oldLeafData)
+		var err error
+		actualPath, oldValueHash, err = smt.th.parseLeaf(oldLeafData)
+		if err != nil {
+			return nil, err
+		}
 		commonPrefixCount = countCommonPrefix(path, actualPath)
 	}
+	if commonPrefixCount == smt.depth() && bytes.Equal(oldValueHash, valueHash) {
+		// Short-circuit if the same value is being set: the leaf, and hence
+		// the root, is unchanged, so nothing is written or orphaned.
+		return pathNodes[len(pathNodes)-1], nil
+	}
+
+	if err := smt.setNode(currentHash, currentData); err != nil {
+		return nil, err
+	}
+	leafDepth := len(sideNodes)
+	currentData = currentHash
 	if commonPrefixCount != smt.depth() {
+		leafDepth = commonPrefixCount + 1
 		if getBitAtFromMSB(path, commonPrefixCount) == right {
-			currentHash, currentData = smt.th.digestNode(pathNodes[0], currentData)
+			currentHash, currentData = smt.th.digestNode(pathNodes[0], currentData, commonPrefixCount)
 		} else {
-			currentHash, currentData = smt.th.digestNode(currentData, pathNodes[0])
+			currentHash, currentData = smt.th.digestNode(currentData, pathNodes[0], commonPrefixCount)
 		}
 
-		err := smt.nodes.Set(currentHash, currentData)
+		err := smt.setNode(currentHash, currentData)
 		if err != nil {
 			return nil, err
 		}
 
 		currentData = currentHash
 	} else if oldValueHash != nil {
-		// Short-circuit if the same value is being set
-		if bytes.Equal(oldValueHash, valueHash) {
-			return smt.root, nil
-		}
 		// If an old leaf exists, remove it
-		if err := smt.nodes.Delete(pathNodes[0]); err != nil {
+		if err := smt.deleteNode(pathNodes[0]); err != nil {
 			return nil, err
 		}
-		if err := smt.values.Delete(path); err != nil {
-			return nil, err
+		// The old value is overwritten below, unless the new leaf is a
+		// tombstone.
+		if !smt.isTombstone(oldValueHash) && smt.isTombstone(valueHash) {
+			if err := smt.values.Delete(path); err != nil {
+				return nil, err
+			}
 		}
 	}
 	// All remaining path nodes are orphaned
 	for i := 1; i < len(pathNodes); i++ {
-		if err := smt.nodes.Delete(pathNodes[i]); err != nil {
+		if err := smt.deleteNode(pathNodes[i]); err != nil {
 			return nil, err
 		}
 	}
@@ -288,19 +1005,30 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, side
 			sideNode = sideNodes[i-offsetOfSideNodes]
 		}
 
-		if getBitAtFromMSB(path, smt.depth()-1-i) == right {
-			currentHash, currentData = smt.th.digestNode(sideNode, currentData)
+		nodeDepth := smt.depth() - 1 - i
+		if getBitAtFromMSB(path, nodeDepth) == right {
+			currentHash, currentData = smt.th.digestNode(sideNode, currentData, nodeDepth)
 		} else {
-			currentHash, currentData = smt.th.digestNode(currentData, sideNode)
+			currentHash, currentData = smt.th.digestNode(currentData, sideNode, nodeDepth)
 		}
-		err := smt.nodes.Set(currentHash, currentData)
+		err := smt.setNode(currentHash, currentData)
 		if err != nil {
 			return nil, err
 		}
 		currentData = currentHash
 	}
-	if err := smt.values.Set(path, value); err != nil {
-		return nil, err
+	if !smt.isTombstone(valueHash) {
+		if err := smt.values.Set(path, value); err != nil {
+			return nil, err
+		}
+	}
+	if bytes.Equal(pathNodes[len(pathNodes)-1], smt.maxDepthRoot) {
+		// Inserting a leaf only deepens the tree along its path, so the
+		// maximum depth can be carried over to the new root.
+		if leafDepth > smt.maxDepth {
+			smt.maxDepth = leafDepth
+		}
+		smt.maxDepthRoot = currentHash
 	}
 
 	return currentHash, nil
@@ -312,6 +1040,11 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, side
 //
 // If the leaf is a placeholder, the leaf data is nil.
 func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSiblingData bool) ([][]byte, [][]byte, []byte, []byte, error) {
+	if len(root) == 0 {
+		// A nil root is an empty tree.
+		root = smt.th.placeholder()
+	}
+
 	// Side nodes for the path. Nodes are inserted in reverse order, then the
 	// slice is reversed at the end.
 	sideNodes := make([][]byte, 0, smt.depth())
@@ -324,7 +1057,7 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 		return sideNodes, pathNodes, nil, nil, nil
 	}
 
-	currentData, err := smt.nodes.Get(root)
+	currentData, err := smt.getNode(root)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	} else if smt.th.isLeaf(currentData) {
@@ -355,7 +1088,7 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 			break
 		}
 
-		currentData, err = smt.nodes.Get(nodeHash)
+		currentData, err = smt.getNode(nodeHash)
 		if err != nil {
 			return nil, nil, nil, nil, err
 		} else if smt.th.isLeaf(currentData) {
@@ -363,9 +1096,13 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 			break
 		}
 	}
+	if currentData != nil && !smt.th.isLeaf(currentData) {
+		// An inner node below the depth of the tree.
+		return nil, nil, nil, nil, innerNodeAtDepthError(pathNodes)
+	}
 
 	if getSiblingData {
-		siblingData, err = smt.nodes.Get(sideNode)
+		siblingData, err = smt.getNode(sideNode)
 		if err != nil {
 			return nil, nil, nil, nil, err
 		}
@@ -379,7 +1116,7 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 // the leaf may be updated (e.g. in a state transition fraud proof). For
 // updatable proofs, see ProveUpdatable.
 func (smt *SparseMerkleTree) Prove(key []byte) (SparseMerkleProof, error) {
-	proof, err := smt.ProveForRoot(key, smt.Root())
+	proof, err := smt.ProveForRoot(key, smt.root)
 	return proof, err
 }
 
@@ -393,9 +1130,17 @@ func (smt *SparseMerkleTree) ProveForRoot(key []byte, root []byte) (SparseMerkle
 	return smt.doProveForRoot(key, root, false)
 }
 
+// ProveForRoot generates a Merkle proof for a key against any root whose nodes
+// are in the given nodes MapStore, without a tree. The options must match
+// those of the tree the root belongs to.
+func ProveForRoot(nodes MapStore, hasher hash.Hash, root []byte, key []byte, options ...Option) (SparseMerkleProof, error) {
+	smt := ImportSparseMerkleTree(nodes, nil, hasher, root, options...)
+	return smt.doProveForRoot(key, smt.root, false)
+}
+
 // ProveUpdatable generates an updatable Merkle proof for a key against the current root.
 func (smt *SparseMerkleTree) ProveUpdatable(key []byte) (SparseMerkleProof, error) {
-	proof, err := smt.ProveUpdatableForRoot(key, smt.Root())
+	proof, err := smt.ProveUpdatableForRoot(key, smt.root)
 	return proof, err
 }
 
@@ -406,12 +1151,55 @@ func (smt *SparseMerkleTree) ProveUpdatableForRoot(key []byte, root []byte) (Spa
 }
 
 func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable bool) (SparseMerkleProof, error) {
+	proof, _, err := smt.proveWithLeaf(key, root, isUpdatable)
+	return proof, err
+}
+
+// proveWithLeaf generates a Merkle proof for a key against a specific root,
+// and returns it along with the data of the leaf at the end of the key's
+// branch, or nil if there is no leaf.
+func (smt *SparseMerkleTree) proveWithLeaf(key []byte, root []byte, isUpdatable bool) (SparseMerkleProof, []byte, error) {
 	path := smt.th.path(key)
 	sideNodes, pathNodes, leafData, siblingData, err := smt.sideNodesForRoot(path, root, isUpdatable)
 	if err != nil {
-		return SparseMerkleProof{}, err
+		return SparseMerkleProof{}, nil, err
 	}
+	proof, err := smt.proofForBranch(path, sideNodes, pathNodes, leafData, siblingData)
+	if err != nil {
+		return SparseMerkleProof{}, nil, err
+	}
+	if smt.fullDepthProofs {
+		padding := make([][]byte, smt.depth()-len(proof.SideNodes))
+		for i := range padding {
+			padding[i] = smt.th.placeholder()
+		}
+		proof.SideNodes = append(padding, proof.SideNodes...)
+	}
+	return proof, leafData, nil
+}
 
+// ProveMembership generates a Merkle proof for a key against the current root,
+// like Prove, and returns whether the key is present, i.e. whether the proof
+// is a membership proof rather than a non-membership proof.
+func (smt *SparseMerkleTree) ProveMembership(key []byte) (bool, SparseMerkleProof, error) {
+	proof, leafData, err := smt.proveWithLeaf(key, smt.root, false)
+	if err != nil {
+		return false, SparseMerkleProof{}, err
+	}
+	if leafData == nil || proof.NonMembershipLeafData != nil {
+		return false, proof, nil
+	}
+	_, valueHash, err := smt.th.parseLeaf(leafData)
+	if err != nil {
+		return false, SparseMerkleProof{}, err
+	}
+	// The leaf of a deleted key is a tombstone.
+	return !smt.isTombstone(valueHash), proof, nil
+}
+
+// proofForBranch builds a Merkle proof from the side nodes, path nodes, leaf
+// data and sibling data of a path, as returned by sideNodesForRoot.
+func (smt *SparseMerkleTree) proofForBranch(path []byte, sideNodes [][]byte, pathNodes [][]byte, leafData []byte, siblingData []byte) (SparseMerkleProof, error) {
 	var nonEmptySideNodes [][]byte
 	for _, v := range sideNodes {
 		if v != nil {
@@ -423,7 +1211,10 @@ func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable
 	// value, we do not need to add anything else to the proof.
 	var nonMembershipLeafData []byte
 	if !bytes.Equal(pathNodes[0], smt.th.placeholder()) {
-		actualPath, _ := smt.th.parseLeaf(leafData)
+		actualPath, _, err := smt.th.parseLeaf(leafData)
+		if err != nil {
+			return SparseMerkleProof{}, err
+		}
 		if !bytes.Equal(actualPath, path) {
 			// This is a non-membership proof that involves showing a different leaf.
 			// Add the leaf data to the proof.
@@ -437,12 +1228,158 @@ func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable
 		SiblingData:           siblingData,
 	}
 
-	return proof, err
+	return proof, nil
+}
+
+// ProofDepth returns the number of side nodes of a proof for a key against
+// the current root, generated by Prove, without generating it. Only the nodes
+// on the key's branch are read.
+func (smt *SparseMerkleTree) ProofDepth(key []byte) (int, error) {
+	if smt.fullDepthProofs {
+		return smt.depth(), nil
+	}
+	path := smt.th.path(key)
+	currentHash := smt.root
+	pathNodes := [][]byte{currentHash}
+	for depth := 0; ; depth++ {
+		if bytes.Equal(currentHash, smt.th.placeholder()) {
+			return depth, nil
+		}
+		currentData, err := smt.getNode(currentHash)
+		if err != nil {
+			return 0, err
+		}
+		if smt.th.isLeaf(currentData) {
+			return depth, nil
+		}
+		if depth >= smt.depth() {
+			return 0, innerNodeAtDepthError(pathNodes)
+		}
+		leftNode, rightNode := smt.th.parseNode(currentData)
+		if getBitAtFromMSB(path, depth) == right {
+			currentHash = rightNode
+		} else {
+			currentHash = leftNode
+		}
+		pathNodes = append(pathNodes, currentHash)
+	}
+}
+
+// ProveCommonAncestor finds the deepest node of the tree that the paths of all
+// the given keys go through, and returns its depth and digest, along with a
+// proof of its position under the root, which can be verified with
+// VerifyCommonAncestorProof. The node is an inner node, unless the tree has
+// none, in which case it is the root at depth 0. The proof consists of the
+// side nodes above the node, bottom-up.
+func (smt *SparseMerkleTree) ProveCommonAncestor(keys [][]byte) (int, []byte, SparseMerkleProof, error) {
+	if len(keys) == 0 {
+		return 0, nil, SparseMerkleProof{}, ErrNoKeys
+	}
+	path := smt.th.path(keys[0])
+	commonBits := smt.depth()
+	for _, key := range keys[1:] {
+		if n := countCommonPrefix(path, smt.th.path(key)); n < commonBits {
+			commonBits = n
+		}
+	}
+	sideNodes, pathNodes, _, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return 0, nil, SparseMerkleProof{}, err
+	}
+
+	// The inner nodes of the branch are at the depths above its end, and
+	// the keys diverge below the node at the depth of their common prefix.
+	ancestorDepth := commonBits
+	if ancestorDepth > len(sideNodes)-1 {
+		ancestorDepth = len(sideNodes) - 1
+	}
+	if ancestorDepth < 0 {
+		ancestorDepth = 0
+	}
+	height := len(sideNodes) - ancestorDepth
+	proof := SparseMerkleProof{SideNodes: sideNodes[height:]}
+	return ancestorDepth, pathNodes[height], proof, nil
+}
+
+// ProveToDepth generates a Merkle proof for a key against the root of the
+// subtree at the given depth on the key's path, rather than against the root
+// of the tree, and returns the proof along with that subtree root. The proof
+// contains only the side nodes below the depth, and can be verified with
+// VerifyProofToDepth; the subtree root can in turn be proven against the root
+// of the tree.
+//
+// ErrInvalidDepth is returned if the depth exceeds that of the tree, or if the
+// key's branch terminates above the depth.
+func (smt *SparseMerkleTree) ProveToDepth(key []byte, depth int) (SparseMerkleProof, []byte, error) {
+	if depth < 0 || depth > smt.depth() {
+		return SparseMerkleProof{}, nil, ErrInvalidDepth
+	}
+	path := smt.th.path(key)
+	sideNodes, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return SparseMerkleProof{}, nil, err
+	}
+	if depth > len(sideNodes) {
+		return SparseMerkleProof{}, nil, ErrInvalidDepth
+	}
+
+	// Side nodes and path nodes are ordered bottom-up, so the node at the
+	// given depth is len(sideNodes)-depth from the bottom.
+	subtreeHeight := len(sideNodes) - depth
+	proof, err := smt.proofForBranch(path, sideNodes[:subtreeHeight], pathNodes[:subtreeHeight+1], leafData, nil)
+	if err != nil {
+		return SparseMerkleProof{}, nil, err
+	}
+	return proof, pathNodes[subtreeHeight], nil
+}
+
+// ProveFrom generates a Merkle proof for a key for a client that already has
+// some verified nodes of the tree, given by their digests. The proof is
+// against the deepest of these nodes on the key's path, rather than against
+// the root, and only contains the side nodes below it; the depth of that node
+// is also returned. The proof can be verified with VerifyProofToDepth against
+// the client's node at that depth. If no node on the path is known, the proof
+// is a full proof against the root, at depth 0.
+func (smt *SparseMerkleTree) ProveFrom(key []byte, knownDigests map[string]bool) (SparseMerkleProof, int, error) {
+	path := smt.th.path(key)
+	sideNodes, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return SparseMerkleProof{}, 0, err
+	}
+
+	depth := 0
+	for d := len(sideNodes); d > 0; d-- {
+		node := pathNodes[len(sideNodes)-d]
+		if knownDigests[string(node)] && !bytes.Equal(node, smt.th.placeholder()) {
+			depth = d
+			break
+		}
+	}
+	subtreeHeight := len(sideNodes) - depth
+	proof, err := smt.proofForBranch(path, sideNodes[:subtreeHeight], pathNodes[:subtreeHeight+1], leafData, nil)
+	if err != nil {
+		return SparseMerkleProof{}, 0, err
+	}
+	return proof, depth, nil
+}
+
+// SiblingDigests returns the digests of the sibling nodes along the path of a
+// key, against the current root. Unlike the side nodes of a proof, they are
+// ordered top-down: the first digest is the sibling of the root's child on the
+// path, and the last is the sibling of the leaf, or of the placeholder where
+// the path diverges from the tree. Placeholder siblings are included.
+func (smt *SparseMerkleTree) SiblingDigests(key []byte) ([][]byte, error) {
+	path := smt.th.path(key)
+	sideNodes, _, _, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return nil, err
+	}
+	return reverseByteSlices(sideNodes), nil
 }
 
 // ProveCompact generates a compacted Merkle proof for a key against the current root.
 func (smt *SparseMerkleTree) ProveCompact(key []byte) (SparseCompactMerkleProof, error) {
-	proof, err := smt.ProveCompactForRoot(key, smt.Root())
+	proof, err := smt.ProveCompactForRoot(key, smt.root)
 	return proof, err
 }
 
@@ -452,6 +1389,96 @@ func (smt *SparseMerkleTree) ProveCompactForRoot(key []byte, root []byte) (Spars
 	if err != nil {
 		return SparseCompactMerkleProof{}, err
 	}
-	compactedProof, err := CompactProof(proof, smt.th.hasher)
+	compactedProof, err := compactProof(proof, &smt.th)
 	return compactedProof, err
 }
+
+// LightProof returns the value of a key, a compacted Merkle proof for it and
+// the current root, all from a single descent of the tree, so that a light
+// client can verify the value against the root with VerifyCompactProof. Like
+// Get, it returns ErrKeyDeleted for a key deleted from a tree with tombstones.
+func (smt *SparseMerkleTree) LightProof(key []byte) ([]byte, SparseCompactMerkleProof, []byte, error) {
+	path := smt.th.path(key)
+	sideNodes, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return nil, SparseCompactMerkleProof{}, nil, err
+	}
+	proof, err := smt.proofForBranch(path, sideNodes, pathNodes, leafData, nil)
+	if err != nil {
+		return nil, SparseCompactMerkleProof{}, nil, err
+	}
+	compactedProof, err := compactProof(proof, &smt.th)
+	if err != nil {
+		return nil, SparseCompactMerkleProof{}, nil, err
+	}
+
+	value := smt.th.copyDefaultValue()
+	if !bytes.Equal(pathNodes[0], smt.th.placeholder()) && proof.NonMembershipLeafData == nil {
+		// The leaf on the path is the key's own leaf.
+		_, valueHash, err := smt.th.parseLeaf(leafData)
+		if err != nil {
+			return nil, SparseCompactMerkleProof{}, nil, err
+		}
+		if smt.isTombstone(valueHash) {
+			return nil, SparseCompactMerkleProof{}, nil, ErrKeyDeleted
+		}
+		value, err = smt.values.Get(path)
+		if err != nil {
+			return nil, SparseCompactMerkleProof{}, nil, err
+		}
+		if smt.copyValues {
+			value = append([]byte(nil), value...)
+		}
+	}
+	return value, compactedProof, smt.Root(), nil
+}
+
+// ProveAdjacent generates a Merkle proof that the leaves of two keys are
+// siblings, hanging off the same inner node. The proof is an updatable
+// membership proof for keyA, whose sibling is the leaf of keyB, and can be
+// verified with VerifyAdjacentProof. ErrNotAdjacent is returned if either key
+// is absent or their leaves are not siblings.
+func (smt *SparseMerkleTree) ProveAdjacent(keyA []byte, keyB []byte) (SparseMerkleProof, error) {
+	pathA, pathB := smt.th.path(keyA), smt.th.path(keyB)
+	sideNodes, pathNodes, leafData, siblingData, err := smt.sideNodesForRoot(pathA, smt.root, true)
+	if err != nil {
+		return SparseMerkleProof{}, err
+	}
+	if bytes.Equal(pathNodes[0], smt.th.placeholder()) || siblingData == nil || !smt.th.isLeaf(siblingData) {
+		return SparseMerkleProof{}, ErrNotAdjacent
+	}
+	actualPath, _, err := smt.th.parseLeaf(leafData)
+	if err != nil {
+		return SparseMerkleProof{}, err
+	}
+	siblingPath, _, err := smt.th.parseLeaf(siblingData)
+	if err != nil {
+		return SparseMerkleProof{}, err
+	}
+	if !bytes.Equal(actualPath, pathA) || !bytes.Equal(siblingPath, pathB) {
+		return SparseMerkleProof{}, ErrNotAdjacent
+	}
+	return smt.proofForBranch(pathA, sideNodes, pathNodes, leafData, siblingData)
+}
+
+// ClosestLeaf descends the tree along the path of a key until it reaches a
+// leaf, and returns the leaf's path and value hash, along with the number of
+// leading bits its path has in common with the key's path, which is the depth
+// at which inserting the key would split the paths. If the key's path instead
+// ends at an empty subtree, the path and value hash are nil, and the depth of
+// the empty subtree is returned.
+func (smt *SparseMerkleTree) ClosestLeaf(key []byte) ([]byte, []byte, int, error) {
+	path := smt.th.path(key)
+	sideNodes, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		return nil, nil, len(sideNodes), nil
+	}
+	leafPath, valueHash, err := smt.th.parseLeaf(leafData)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return leafPath, valueHash, countCommonPrefix(path, leafPath), nil
+}
@@ -3,8 +3,12 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"hash"
 	"math/rand"
+	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -614,3 +618,1156 @@ func TestOrphanRemoval(t *testing.T) {
 		}
 	})
 }
+
+// Test that a batched get matches individual gets.
+func TestSparseMerkleTreeGetBatch(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+
+	keys := [][]byte{[]byte("testKey3"), []byte("testKey1"), []byte("testKey2"), []byte("testKey1")}
+	values, err := smt.GetBatch(keys)
+	if err != nil {
+		t.Errorf("returned error when getting batch from empty tree: %v", err)
+	}
+	for i := range keys {
+		if !bytes.Equal(defaultValue, values[i]) {
+			t.Error("did not get default value when getting empty key")
+		}
+	}
+
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	values, err = smt.GetBatch(keys)
+	if err != nil {
+		t.Errorf("returned error when getting batch: %v", err)
+	}
+	if len(values) != len(keys) {
+		t.Fatalf("expected %d values, got: %d", len(keys), len(values))
+	}
+	for i, key := range keys {
+		value, err := smt.Get(key)
+		if err != nil {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, values[i]) {
+			t.Error("batched value does not match individual get")
+		}
+	}
+}
+
+// Test that the orphan and persist callbacks mirror the nodes MapStore.
+func TestSparseMerkleTreeNodeCallbacks(t *testing.T) {
+	live := make(map[string]string)
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(),
+		WithPersistCallback(func(digest, data []byte) {
+			live[string(digest)] = string(data)
+		}),
+		WithOrphanCallback(func(digest []byte) {
+			delete(live, string(digest))
+		}),
+	)
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(rand.Intn(20)))
+		if rand.Intn(3) == 0 {
+			smt.Delete(key)
+		} else {
+			smt.Update(key, []byte(strconv.Itoa(i)))
+		}
+	}
+
+	if len(live) != len(smn.m) {
+		t.Fatalf("expected %d live nodes, got: %d", len(smn.m), len(live))
+	}
+	for digest, data := range smn.m {
+		if live[digest] != string(data) {
+			t.Error("live node set does not match nodes MapStore")
+		}
+	}
+}
+
+// Test that truncated and overlong leaf data is rejected rather than misread.
+func TestSparseMerkleTreeBadLeaf(t *testing.T) {
+	for _, corrupt := range []func([]byte) []byte{
+		func(data []byte) []byte { return data[:len(data)-1] },
+		func(data []byte) []byte { return append(append([]byte{}, data...), 0) },
+	} {
+		smn, smv := NewSimpleMap(), NewSimpleMap()
+		smt := NewSparseMerkleTree(smn, smv, sha256.New())
+		root, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+		smn.m[string(root)] = corrupt(smn.m[string(root)])
+
+		if _, err := smt.GetDescend([]byte("testKey")); err != ErrBadLeaf {
+			t.Errorf("did not return ErrBadLeaf when getting key: %v", err)
+		}
+		if _, err := smt.Prove([]byte("testKey2")); err != ErrBadLeaf {
+			t.Errorf("did not return ErrBadLeaf when proving key: %v", err)
+		}
+		if _, err := smt.Update([]byte("testKey2"), []byte("testValue2")); err != ErrBadLeaf {
+			t.Errorf("did not return ErrBadLeaf when updating key: %v", err)
+		}
+		if _, err := smt.Delete([]byte("testKey")); err != ErrBadLeaf {
+			t.Errorf("did not return ErrBadLeaf when deleting key: %v", err)
+		}
+		if !bytes.Equal(root, smt.Root()) {
+			t.Error("tree root changed after failed operations")
+		}
+	}
+
+	// Case: a leaf is encoded with a path or value hash of the wrong size.
+	th := newTreeHasher(sha256.New())
+	path := th.path([]byte("testKey"))
+	if err := th.checkLeaf(path, th.digest([]byte("testValue"))); err != nil {
+		t.Errorf("returned error for valid leaf: %v", err)
+	}
+	if err := th.checkLeaf(path, []byte("testValue")); err != ErrBadLeaf {
+		t.Errorf("did not return ErrBadLeaf for leaf with value hash of wrong size: %v", err)
+	}
+	if err := th.checkLeaf(path[1:], th.digest([]byte("testValue"))); err != ErrBadLeaf {
+		t.Errorf("did not return ErrBadLeaf for leaf with path of wrong size: %v", err)
+	}
+}
+
+// Test that orphaned nodes are kept when orphan tracking is disabled.
+func TestSparseMerkleTreeNoOrphanTracking(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithNoOrphanTracking())
+
+	root1, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+	_, err := smt.Update([]byte("testKey2"), []byte("testValue2"))
+	if err != nil {
+		t.Errorf("returned error when updating key: %v", err)
+	}
+	_, err = smt.Update([]byte("testKey"), []byte("testValue3"))
+	if err != nil {
+		t.Errorf("returned error when updating key: %v", err)
+	}
+	// 2 roots + 3 leaves
+	if len(smn.m) != 5 {
+		t.Errorf("expected 5 nodes after updates, got: %d", len(smn.m))
+	}
+
+	// The nodes of previous roots are still provable.
+	proof, err := smt.ProveForRoot([]byte("testKey"), root1)
+	if err != nil {
+		t.Errorf("returned error when proving key against previous root: %v", err)
+	}
+	if !VerifyProof(proof, root1, []byte("testKey"), []byte("testValue"), sha256.New()) {
+		t.Error("valid proof against previous root failed to verify")
+	}
+
+	root := smt.Root()
+	if _, err := smt.Delete([]byte("testKey")); err != ErrNoOrphanTracking {
+		t.Errorf("did not return ErrNoOrphanTracking when deleting key: %v", err)
+	}
+	if !bytes.Equal(root, smt.Root()) {
+		t.Error("tree root changed after failed delete")
+	}
+}
+
+// Test that PendingOrphans lists the nodes left unreachable from the root.
+func TestSparseMerkleTreePendingOrphans(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithNoOrphanTracking())
+	if orphans := smt.PendingOrphans(); len(orphans) != 0 {
+		t.Errorf("expected no pending orphans in empty tree, got: %d", len(orphans))
+	}
+
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i%7))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		if _, err := smt.Update(key, value); err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+	}
+	// Setting a key back to a previous value makes its old leaf live again.
+	if _, err := smt.Update([]byte("testKey0"), []byte("testValue0")); err != nil {
+		t.Fatalf("returned error when updating key: %v", err)
+	}
+
+	live := make(map[string]bool)
+	err := smt.walkNodes(smt.Root(), 0, func(digest, _ []byte) error {
+		live[string(digest)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("returned error when walking nodes: %v", err)
+	}
+	orphans := smt.PendingOrphans()
+	if len(orphans)+len(live) != len(smn.m) {
+		t.Errorf("expected %d pending orphans, got: %d", len(smn.m)-len(live), len(orphans))
+	}
+	for i, orphan := range orphans {
+		if live[string(orphan)] {
+			t.Errorf("pending orphan %x is reachable from the root", orphan)
+		}
+		if i > 0 && bytes.Compare(orphans[i-1], orphan) >= 0 {
+			t.Error("pending orphans are not sorted and unique")
+		}
+	}
+
+	// Pruning the pending orphans leaves the tree intact.
+	for _, orphan := range orphans {
+		if err := smn.Delete(orphan); err != nil {
+			t.Fatalf("returned error when deleting orphan: %v", err)
+		}
+	}
+	for i := 0; i < 7; i++ {
+		if has, err := smt.Has([]byte(fmt.Sprintf("testKey%d", i))); err != nil || !has {
+			t.Errorf("key missing after pruning orphans: %v", err)
+		}
+	}
+}
+
+// Test that the root of an empty tree is nil with WithEmptyRootNil.
+func TestSparseMerkleTreeEmptyRootNil(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithEmptyRootNil())
+	if smt.Root() != nil {
+		t.Error("root of empty tree is not nil")
+	}
+
+	proof, err := smt.Prove([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when proving key in empty tree: %v", err)
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), defaultValue, sha256.New()) {
+		t.Error("valid proof against nil root failed to verify")
+	}
+
+	root, err := smt.Update([]byte("testKey"), []byte("testValue"))
+	if err != nil {
+		t.Errorf("returned error when updating key: %v", err)
+	}
+	if root == nil || !bytes.Equal(root, smt.Root()) {
+		t.Error("root of non-empty tree is not as expected")
+	}
+
+	root, err = smt.Delete([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when deleting key: %v", err)
+	}
+	if root != nil || smt.Root() != nil {
+		t.Error("root of tree is not nil after deleting all keys")
+	}
+
+	// A nil root can be imported, and is an empty tree.
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), nil)
+	if !bytes.Equal(imported.Root(), imported.th.placeholder()) {
+		t.Error("imported nil root is not the placeholder")
+	}
+	if _, err := imported.Update([]byte("testKey"), []byte("testValue")); err != nil {
+		t.Errorf("returned error when updating key in imported empty tree: %v", err)
+	}
+	value, err := imported.GetDescend([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal([]byte("testValue"), value) {
+		t.Error("did not get correct value when getting key")
+	}
+}
+
+// truncatedHasher reports a smaller size than the digests it produces.
+type truncatedHasher struct {
+	hash.Hash
+}
+
+func (truncatedHasher) Size() int {
+	return 16
+}
+
+func TestSparseMerkleTreeInvalidPathLength(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), truncatedHasher{sha256.New()})
+
+	_, err := smt.Update([]byte("testKey"), []byte("testValue"))
+	if !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath when updating with mismatched hasher, got: %v", err)
+	}
+}
+
+func TestSparseMerkleTreeUpdatePreview(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 10; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	root := smt.Root()
+	numNodes, numValues := len(smn.m), len(smv.m)
+
+	for _, value := range [][]byte{[]byte("new"), []byte("3"), defaultValue} {
+		previewRoot, err := smt.UpdatePreview([]byte("3"), value)
+		if err != nil {
+			t.Errorf("returned error when previewing update: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), root) || len(smn.m) != numNodes || len(smv.m) != numValues {
+			t.Error("tree was modified by preview")
+		}
+
+		smt2 := ImportSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), nil)
+		for i := 0; i < 10; i++ {
+			s := strconv.Itoa(i)
+			if s == "3" {
+				smt2.Update([]byte(s), value)
+			} else {
+				smt2.Update([]byte(s), []byte(s))
+			}
+		}
+		if !bytes.Equal(previewRoot, smt2.Root()) {
+			t.Error("preview root does not match root after update")
+		}
+	}
+}
+
+// Test that UpdatePreview does not modify the state of the tree kept in
+// memory.
+func TestSparseMerkleTreeUpdatePreviewState(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(),
+		WithAccessTracking(10), WithUndo(10), WithVersionedLeaves(), WithNoOrphanTracking())
+	smt.Update([]byte("a"), []byte("a"))
+	recentKeys, version := smt.RecentKeys(), smt.Version()
+
+	if _, err := smt.UpdatePreview([]byte("b"), []byte("b")); err != nil {
+		t.Fatalf("returned error when previewing update: %v", err)
+	}
+	if !reflect.DeepEqual(smt.RecentKeys(), recentKeys) {
+		t.Error("preview was recorded by access tracking")
+	}
+	if smt.Version() != version {
+		t.Error("preview advanced the version")
+	}
+	if len(smt.undoStack) != 1 || len(smt.PendingOrphans()) != 0 {
+		t.Error("preview modified the undo stack or pending orphans")
+	}
+}
+
+func TestSparseMerkleTreeVerifyOnRead(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithVerifyOnRead())
+	for i := 0; i < 10; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	if _, err := smt.Prove([]byte("3")); err != nil {
+		t.Errorf("returned error when proving key with intact store: %v", err)
+	}
+
+	// Corrupt the leaf of a key.
+	leafHash, leafData := smt.th.digestLeaf(smt.th.path([]byte("3")), smt.th.digest([]byte("3")))
+	corruptData := make([]byte, len(leafData))
+	copy(corruptData, leafData)
+	corruptData[len(corruptData)-1] ^= 1
+	smn.Set(leafHash, corruptData)
+
+	if _, err := smt.Prove([]byte("3")); !errors.Is(err, ErrNodeCorrupt) {
+		t.Errorf("expected ErrNodeCorrupt when proving key with corrupt leaf, got: %v", err)
+	}
+	if _, err := smt.Update([]byte("3"), []byte("new")); !errors.Is(err, ErrNodeCorrupt) {
+		t.Errorf("expected ErrNodeCorrupt when updating key with corrupt leaf, got: %v", err)
+	}
+}
+
+func TestSparseMerkleTreePathLength(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathLength(8))
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		value, err := smt.Get([]byte(s))
+		if err != nil || !bytes.Equal(value, []byte(s)) {
+			t.Error("did not get correct value when getting key")
+		}
+		proof, err := smt.Prove([]byte(s))
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if len(proof.SideNodes) > 64 {
+			t.Error("proof has more side nodes than the depth of the tree")
+		}
+		if !VerifyProof(proof, smt.Root(), []byte(s), []byte(s), sha256.New(), WithPathLength(8)) {
+			t.Error("valid proof failed to verify")
+		}
+		if VerifyProof(proof, smt.Root(), []byte(s), []byte(s), sha256.New()) {
+			t.Error("proof verified without path length")
+		}
+	}
+	for i := 0; i < 20; i++ {
+		smt.Delete([]byte(strconv.Itoa(i)))
+	}
+	if !bytes.Equal(smt.Root(), smt.th.placeholder()) {
+		t.Error("tree is not empty after deleting all keys")
+	}
+
+	// Keys whose paths collide are the same entry.
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathLength(1))
+	seen := make(map[byte]string)
+	var key1, key2 string
+	for i := 0; key1 == ""; i++ {
+		s := strconv.Itoa(i)
+		path := smt.th.path([]byte(s))
+		if other, ok := seen[path[0]]; ok {
+			key1, key2 = other, s
+		}
+		seen[path[0]] = s
+	}
+	smt.Update([]byte(key1), []byte("value1"))
+	smt.Update([]byte(key2), []byte("value2"))
+	value, _ := smt.Get([]byte(key1))
+	if !bytes.Equal(value, []byte("value2")) {
+		t.Error("colliding key did not get last written value")
+	}
+}
+
+func TestSparseMerkleTreeCopyValues(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithCopyValues())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+
+	value, _ := smt.Get([]byte("testKey"))
+	value[0] = 'x'
+	value, _ = smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("modifying returned value modified the tree")
+	}
+
+	values, _ := smt.GetBatch([][]byte{[]byte("testKey")})
+	values[0][0] = 'x'
+	value, _ = smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("modifying returned batch value modified the tree")
+	}
+}
+
+func TestSparseMerkleTreeDefaultValue(t *testing.T) {
+	zero := []byte("zero")
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDefaultValue(zero))
+
+	value, _ := smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, zero) {
+		t.Error("did not get default value when getting absent key from empty tree")
+	}
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	value, _ = smt.Get([]byte("testKey2"))
+	if !bytes.Equal(value, zero) {
+		t.Error("did not get default value when getting absent key")
+	}
+	has, _ := smt.Has([]byte("testKey2"))
+	if has {
+		t.Error("absent key reported as present")
+	}
+	proof, _ := smt.Prove([]byte("testKey2"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey2"), zero, sha256.New(), WithDefaultValue(zero)) {
+		t.Error("non-membership proof for default value failed to verify")
+	}
+
+	// Setting the default value deletes the key.
+	root, _ := smt.Update([]byte("testKey"), zero)
+	if !bytes.Equal(root, smt.th.placeholder()) {
+		t.Error("setting default value did not delete key")
+	}
+	proof, _ = smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), zero, sha256.New(), WithDefaultValue(zero)) {
+		t.Error("non-membership proof for deleted key failed to verify")
+	}
+
+	// Empty values are ordinary values.
+	smt.Update([]byte("testKey"), []byte{})
+	has, _ = smt.Has([]byte("testKey"))
+	if !has {
+		t.Error("key with empty value reported as absent")
+	}
+	proof, _ = smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), []byte{}, sha256.New(), WithDefaultValue(zero)) {
+		t.Error("membership proof for empty value failed to verify")
+	}
+}
+
+// Test that modifying the default value passed to the option, or returned by
+// Get, does not modify the tree's default value.
+func TestSparseMerkleTreeDefaultValueCopied(t *testing.T) {
+	zero := []byte("zero")
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDefaultValue(zero))
+	zero[0] = 'h'
+
+	value, _ := smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("zero")) {
+		t.Error("modifying the option's value modified the default value")
+	}
+	value[0] = 'h'
+	value, _ = smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("zero")) {
+		t.Error("modifying the returned value modified the default value")
+	}
+	has, _ := smt.Has([]byte("testKey"))
+	if has {
+		t.Error("absent key reported as present")
+	}
+}
+
+func TestSparseMerkleTreeEmptyValueDeletes(t *testing.T) {
+	zero := []byte("zero")
+	options := []Option{WithDefaultValue(zero), WithEmptyValueDeletes()}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), options...)
+
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	root, err := smt.Update([]byte("testKey"), nil)
+	if err != nil {
+		t.Errorf("returned error when updating key to empty value: %v", err)
+	}
+	if !bytes.Equal(root, smt.th.placeholder()) {
+		t.Error("setting empty value did not delete key")
+	}
+	value, _ := smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, zero) {
+		t.Error("did not get default value for key deleted with empty value")
+	}
+
+	// A proof for an empty value is a non-membership proof.
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey"), []byte{})
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), []byte{}, sha256.New(), options...) {
+		t.Error("non-membership proof for empty value failed to verify")
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), zero, sha256.New(), options...) {
+		t.Error("non-membership proof for default value failed to verify")
+	}
+	if VerifyProof(proof, smt.Root(), []byte("testKey"), []byte{}, sha256.New(), WithDefaultValue(zero)) {
+		t.Error("non-membership proof verified as membership proof for empty value")
+	}
+
+	// Pairs with empty values are ignored when building a tree.
+	built, err := BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), []KVPair{
+		{Key: []byte("testKey"), Value: nil},
+		{Key: []byte("testKey2"), Value: []byte("testValue2")},
+	}, options...)
+	if err != nil {
+		t.Errorf("returned error when building tree: %v", err)
+	}
+	if !bytes.Equal(built.Root(), smt.Root()) {
+		t.Error("built tree with empty value does not match")
+	}
+}
+
+func TestSparseMerkleTreeClosestLeaf(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	path, _, commonBits, err := smt.ClosestLeaf([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when finding closest leaf in empty tree: %v", err)
+	}
+	if path != nil || commonBits != 0 {
+		t.Error("found closest leaf in empty tree")
+	}
+
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	path, valueHash, commonBits, err := smt.ClosestLeaf([]byte("3"))
+	if err != nil {
+		t.Errorf("returned error when finding closest leaf: %v", err)
+	}
+	if !bytes.Equal(path, smt.th.path([]byte("3"))) || !bytes.Equal(valueHash, smt.th.digest([]byte("3"))) || commonBits != smt.depth() {
+		t.Error("closest leaf of present key is not its own leaf")
+	}
+
+	for i := 20; i < 40; i++ {
+		key := []byte(strconv.Itoa(i))
+		path, _, commonBits, err := smt.ClosestLeaf(key)
+		if err != nil {
+			t.Errorf("returned error when finding closest leaf: %v", err)
+		}
+		proof, _ := smt.Prove(key)
+		if path == nil {
+			if proof.NonMembershipLeafData != nil || commonBits != len(proof.SideNodes) {
+				t.Error("closest leaf not found for key with leaf on its path")
+			}
+			continue
+		}
+		if commonBits != countCommonPrefix(smt.th.path(key), path) || commonBits < len(proof.SideNodes) {
+			t.Error("unexpected common bits for closest leaf")
+		}
+	}
+}
+
+func TestSparseMerkleTreeDepthBoundDigests(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), WithDepthBoundDigests())
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var kvs []KVPair
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+		plain.Update([]byte(s), []byte(s))
+		kvs = append(kvs, KVPair{Key: []byte(s), Value: []byte(s)})
+	}
+	if bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("depth-bound root is the same as plain root")
+	}
+	built, _ := BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs, WithDepthBoundDigests())
+	if !bytes.Equal(smt.Root(), built.Root()) {
+		t.Error("built depth-bound root does not match updated root")
+	}
+
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		proof, _ := smt.ProveUpdatable([]byte(s))
+		if !VerifyProof(proof, smt.Root(), []byte(s), []byte(s), sha256.New(), WithDepthBoundDigests()) {
+			t.Error("valid depth-bound proof failed to verify")
+		}
+		if VerifyProof(proof, smt.Root(), []byte(s), []byte(s), sha256.New()) {
+			t.Error("depth-bound proof verified without option")
+		}
+	}
+
+	// Deleting keys must restore the roots of the smaller trees.
+	for i := 49; i >= 25; i-- {
+		smt.Delete([]byte(strconv.Itoa(i)))
+	}
+	built, _ = BuildSMT(NewSimpleMap(), NewSimpleMap(), sha256.New(), kvs[:25], WithDepthBoundDigests())
+	if !bytes.Equal(smt.Root(), built.Root()) {
+		t.Error("depth-bound root after deletions does not match")
+	}
+	for hash, data := range smn.m {
+		if !bytes.Equal(smt.th.digestData(data), []byte(hash)) {
+			t.Error("stored node does not hash to its digest")
+		}
+	}
+}
+
+func TestSparseMerkleTreeUpdateMany(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var keys [][]byte
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+		expected.Update([]byte(s), []byte(s))
+		keys = append(keys, []byte(strconv.Itoa(i*2)))
+	}
+
+	if err := smt.UpdateMany(keys, []byte("value")); err != nil {
+		t.Errorf("returned error when updating many keys: %v", err)
+	}
+	for _, key := range keys {
+		expected.Update(key, []byte("value"))
+	}
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root after updating many keys does not match")
+	}
+
+	if err := smt.UpdateMany(keys, defaultValue); err != nil {
+		t.Errorf("returned error when deleting many keys: %v", err)
+	}
+	for _, key := range keys {
+		expected.Delete(key)
+	}
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root after deleting many keys does not match")
+	}
+}
+
+// Test that a failed UpdateMany leaves the keys before the failing one updated,
+// and its write-ahead log matches them.
+func TestSparseMerkleTreeUpdateManyFailure(t *testing.T) {
+	smn, smv := NewSimpleMap(), &failOnceMapStore{MapStore: NewSimpleMap()}
+	var wal bytes.Buffer
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithWAL(&wal))
+	keys := [][]byte{[]byte("testKey"), []byte("testKey2"), []byte("testKey3")}
+	smv.failIn = 2
+	if err := smt.UpdateMany(keys, []byte("testValue")); err != errFailOnce {
+		t.Fatalf("did not return error of values store: %v", err)
+	}
+
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	expected.Update(keys[0], []byte("testValue"))
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root after failed UpdateMany does not match the keys updated")
+	}
+	if err := smt.ValidateAgainstStore(); err != nil {
+		t.Errorf("tree is inconsistent with the store after failed UpdateMany: %v", err)
+	}
+	// The log ends with the record of the failed key, which replays.
+	recovered := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if err := recovered.ReplayWAL(&wal); err != nil {
+		t.Fatalf("returned error when replaying WAL: %v", err)
+	}
+	expected.Update(keys[1], []byte("testValue"))
+	if !bytes.Equal(recovered.Root(), expected.Root()) {
+		t.Error("WAL holds records of keys that were not attempted")
+	}
+
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	smt.Update(keys[0], []byte("testValue"))
+	root := smt.Root()
+	if err := smt.UpdateMany(keys, defaultValue); err != ErrNoOrphanTracking {
+		t.Errorf("did not return ErrNoOrphanTracking when deleting many keys: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("root changed after rejected UpdateMany")
+	}
+}
+
+func TestSparseMerkleTreeCycleDetected(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+
+	// Store an inner node that is its own child.
+	cycle := make([]byte, smt.th.pathSize())
+	cycle[0] = 1
+	_, data := smt.th.digestNode(cycle, cycle, 0)
+	smn.Set(cycle, data)
+	smt.SetRoot(cycle)
+
+	if _, _, err := smt.IterateFrom(nil, 0); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected ErrCycleDetected when iterating cyclic tree, got: %v", err)
+	}
+	if _, err := smt.SubtreeNodeCount(nil, 0); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected ErrCycleDetected when counting nodes of cyclic tree, got: %v", err)
+	}
+	if _, err := smt.Normalize(); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected ErrCycleDetected when normalizing cyclic tree, got: %v", err)
+	}
+	if _, err := smt.Prove([]byte("testKey")); !errors.Is(err, ErrCycleDetected) {
+		t.Errorf("expected ErrCycleDetected when proving key in cyclic tree, got: %v", err)
+	}
+}
+
+func TestSparseMerkleTreeUnexpectedInnerNode(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), WithPathLength(1))
+	path := smt.Path([]byte("testKey"))
+
+	// Store a chain of distinct inner nodes along the path of the key, one
+	// level deeper than the tree.
+	child := bytes.Repeat([]byte{1}, sha256.Size)
+	current, data := smt.th.digestNode(child, child, smt.depth())
+	smn.Set(current, data)
+	for depth := smt.depth() - 1; depth >= 0; depth-- {
+		if getBitAtFromMSB(path, depth) == right {
+			current, data = smt.th.digestNode(smt.th.placeholder(), current, depth)
+		} else {
+			current, data = smt.th.digestNode(current, smt.th.placeholder(), depth)
+		}
+		smn.Set(current, data)
+	}
+	smt.SetRoot(current)
+
+	if _, err := smt.GetDescend([]byte("testKey")); err != ErrUnexpectedInnerNode {
+		t.Errorf("expected ErrUnexpectedInnerNode when getting key, got: %v", err)
+	}
+	if _, err := smt.Prove([]byte("testKey")); err != ErrUnexpectedInnerNode {
+		t.Errorf("expected ErrUnexpectedInnerNode when proving key, got: %v", err)
+	}
+	if _, err := smt.Update([]byte("testKey"), []byte("testValue")); err != ErrUnexpectedInnerNode {
+		t.Errorf("expected ErrUnexpectedInnerNode when updating key, got: %v", err)
+	}
+}
+
+func TestSparseMerkleTreeRootNode(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithLeafSalt([]byte("salt")))
+	data, err := smt.RootNode()
+	if err != nil || data != nil {
+		t.Error("did not get nil root node for empty tree")
+	}
+
+	for i := 0; i < 2; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+		data, err = smt.RootNode()
+		if err != nil {
+			t.Errorf("returned error when getting root node: %v", err)
+		}
+		if smt.th.isLeaf(data) != (i == 0) {
+			t.Error("root node is not of the expected kind")
+		}
+		if !bytes.Equal(smt.th.digestData(data), smt.Root()) {
+			t.Error("root node does not hash to root")
+		}
+	}
+}
+
+func TestSparseMerkleTreeDomainTags(t *testing.T) {
+	tags := WithDomainTags(0x00, 0x01)
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), tags, WithVerifyOnRead())
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+		plain.Update([]byte(s), []byte(s))
+	}
+	if bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("domain tags did not change root")
+	}
+	if err := smt.ValidateAgainstStore(); err != nil {
+		t.Errorf("tree with domain tags does not validate: %v", err)
+	}
+
+	proof, err := smt.Prove([]byte("3"))
+	if err != nil {
+		t.Errorf("returned error when proving key: %v", err)
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("3"), []byte("3"), sha256.New(), tags) {
+		t.Error("proof with domain tags failed to verify")
+	}
+	if VerifyProof(proof, smt.Root(), []byte("3"), []byte("3"), sha256.New()) {
+		t.Error("proof with domain tags verified without them")
+	}
+	if VerifyProof(proof, smt.Root(), []byte("3"), []byte("3"), sha256.New(), WithDomainTags(0x01, 0x00)) {
+		t.Error("proof with domain tags verified with swapped tags")
+	}
+}
+
+func TestSparseMerkleTreeGetOrDefault(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithTombstones())
+	fallback := []byte("missing")
+
+	value, err := smt.GetOrDefault([]byte("testKey"), fallback)
+	if err != nil || !bytes.Equal(value, fallback) {
+		t.Error("did not get fallback for absent key in empty tree")
+	}
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	value, err = smt.GetOrDefault([]byte("testKey"), fallback)
+	if err != nil || !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get value for present key")
+	}
+	value, err = smt.GetOrDefault([]byte("testKey3"), fallback)
+	if err != nil || !bytes.Equal(value, fallback) {
+		t.Error("did not get fallback for absent key")
+	}
+	smt.Delete([]byte("testKey"))
+	value, err = smt.GetOrDefault([]byte("testKey"), fallback)
+	if err != nil || !bytes.Equal(value, fallback) {
+		t.Error("did not get fallback for deleted key")
+	}
+
+	smt.Close()
+	if _, err := smt.GetOrDefault([]byte("testKey2"), fallback); err != ErrClosed {
+		t.Errorf("did not propagate error reading tree: %v", err)
+	}
+}
+
+func TestSparseMerkleTreePath(t *testing.T) {
+	smv := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), smv, sha256.New())
+	sum := sha256.Sum256([]byte("testKey"))
+	if !bytes.Equal(smt.Path([]byte("testKey")), sum[:]) {
+		t.Error("path is not the hash of the key")
+	}
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if _, ok := smv.m[string(smt.Path([]byte("testKey")))]; !ok {
+		t.Error("value is not stored under the path of the key")
+	}
+
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathLength(8))
+	if !bytes.Equal(smt.Path([]byte("testKey")), sum[:8]) {
+		t.Error("path is not truncated to the path length")
+	}
+}
+
+func TestSparseMerkleTreeEmptyKey(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if _, err := smt.Update([]byte{}, []byte("empty")); err != nil {
+		t.Errorf("returned error when updating empty key: %v", err)
+	}
+	value, err := smt.Get(nil)
+	if err != nil || !bytes.Equal(value, []byte("empty")) {
+		t.Error("did not get value of empty key")
+	}
+	proof, _ := smt.Prove(nil)
+	if !VerifyProof(proof, smt.Root(), nil, []byte("empty"), sha256.New()) {
+		t.Error("proof for empty key failed to verify")
+	}
+	if _, err := smt.Delete(nil); err != nil {
+		t.Errorf("returned error when deleting empty key: %v", err)
+	}
+
+	var wal bytes.Buffer
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRejectEmptyKey(), WithWAL(&wal))
+	if _, err := smt.Update(nil, []byte("empty")); err != ErrEmptyKey {
+		t.Errorf("did not return ErrEmptyKey when updating empty key: %v", err)
+	}
+	if _, err := smt.Delete([]byte{}); err != ErrEmptyKey {
+		t.Errorf("did not return ErrEmptyKey when deleting empty key: %v", err)
+	}
+	if _, err := smt.Get(nil); err != ErrEmptyKey {
+		t.Errorf("did not return ErrEmptyKey when getting empty key: %v", err)
+	}
+	if err := smt.UpdateMany([][]byte{[]byte("testKey"), nil}, []byte("value")); err != ErrEmptyKey {
+		t.Errorf("did not return ErrEmptyKey when updating many keys: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), smt.th.placeholder()) || wal.Len() != 0 {
+		t.Error("rejected update modified the tree")
+	}
+	if _, err := smt.Update([]byte("testKey"), []byte("testValue")); err != nil {
+		t.Errorf("returned error when updating non-empty key: %v", err)
+	}
+}
+
+func TestSparseMerkleTreePathBits(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathLength(8))
+	path := smt.Path([]byte("testKey"))
+	bits := smt.PathBits([]byte("testKey"), 12)
+	if len(bits) != 12 {
+		t.Fatalf("expected 12 bits, got: %d", len(bits))
+	}
+	for i, bit := range bits {
+		if bit != (path[i/8]&(1<<uint(7-i%8)) != 0) {
+			t.Errorf("bit %d does not match path", i)
+		}
+	}
+	if len(smt.PathBits([]byte("testKey"), 1000)) != 64 {
+		t.Error("bits were not capped at the depth of the tree")
+	}
+	if len(smt.PathBits([]byte("testKey"), -1)) != 0 {
+		t.Error("did not get no bits for negative depth")
+	}
+}
+
+func TestSparseMerkleTreeFormatVersion(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithFormatVersion(1))
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+		plain.Update([]byte(s), []byte(s))
+	}
+	smt.Delete([]byte("3"))
+	plain.Delete([]byte("3"))
+	if bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("tree with format version has the same root as default tree")
+	}
+	for _, data := range smn.m {
+		if data[0] != 1 {
+			t.Error("node was not serialized with format version")
+		}
+	}
+	for _, key := range []string{"4", "3"} {
+		value, _ := smt.Get([]byte(key))
+		proof, err := smt.Prove([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), []byte(key), value, sha256.New(), WithFormatVersion(1)) {
+			t.Error("valid proof failed to verify")
+		}
+		if VerifyProof(proof, smt.Root(), []byte(key), value, sha256.New(), WithFormatVersion(2)) {
+			t.Error("proof verified with another format version")
+		}
+	}
+	if err := smt.ValidateAgainstStore(); err != nil {
+		t.Errorf("returned error when validating tree: %v", err)
+	}
+
+	other := ImportSparseMerkleTree(smn, smv, sha256.New(), smt.Root(), WithFormatVersion(2))
+	if _, err := other.Prove([]byte("4")); !errors.Is(err, ErrFormatVersionMismatch) {
+		t.Errorf("expected ErrFormatVersionMismatch when reading node of another version, got: %v", err)
+	}
+	if _, err := other.Update([]byte("4"), []byte("x")); !errors.Is(err, ErrFormatVersionMismatch) {
+		t.Errorf("expected ErrFormatVersionMismatch when updating tree of another version, got: %v", err)
+	}
+}
+
+func TestSparseMerkleTreeUpdateSameValue(t *testing.T) {
+	var orphans, persisted int
+	smn := &countingMapStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(),
+		WithOrphanCallback(func(digest []byte) { orphans++ }),
+		WithPersistCallback(func(digest, data []byte) { persisted++ }))
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	smt.Update([]byte("3"), []byte("updated"))
+
+	orphans, persisted, smn.calls = 0, 0, 0
+	root, err := smt.Update([]byte("3"), []byte("updated"))
+	if err != nil {
+		t.Errorf("returned error when updating key to its value: %v", err)
+	}
+	if orphans != 0 || persisted != 0 || smn.calls != 0 {
+		t.Error("updating key to its value wrote to the nodes MapStore")
+	}
+	if !bytes.Equal(root, smt.Root()) {
+		t.Error("updating key to its value changed the root")
+	}
+
+	// The short-circuit returns the root it was given, not the current one.
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	oldRoot := smt.Root()
+	smt.Update([]byte("3"), []byte("updated"))
+	root, err = smt.UpdateForRoot([]byte("4"), []byte("4"), oldRoot)
+	if err != nil || !bytes.Equal(root, oldRoot) {
+		t.Error("updating key to its value at an old root did not return the old root")
+	}
+}
+
+func TestDeletePath(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	root, _ := smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	path := smt.Path([]byte("testKey"))
+	value, err := smt.GetPathValue(path)
+	if err != nil {
+		t.Errorf("returned error when getting value by path: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get correct value by path")
+	}
+
+	if err := smt.DeletePath(path); err != nil {
+		t.Errorf("returned error when deleting by path: %v", err)
+	}
+	value, err = smt.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting deleted key: %v", err)
+	}
+	if !bytes.Equal(value, defaultValue) {
+		t.Error("key was not deleted by path")
+	}
+	if bytes.Equal(smt.Root(), root) {
+		t.Error("root did not change after deleting by path")
+	}
+
+	// The root is the same as after deleting the key.
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	expected.Update([]byte("testKey2"), []byte("testValue2"))
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root after deleting by path does not match root after deleting key")
+	}
+
+	// Deleting an absent path leaves the root unchanged.
+	root = smt.Root()
+	if err := smt.DeletePath(path); err != nil {
+		t.Errorf("returned error when deleting absent path: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("root changed after deleting absent path")
+	}
+
+	if err := smt.DeletePath(path[1:]); err != ErrInvalidPath {
+		t.Errorf("did not return ErrInvalidPath when deleting short path: %v", err)
+	}
+	if _, err := smt.GetPathValue(append(path, 0)); err != ErrInvalidPath {
+		t.Errorf("did not return ErrInvalidPath when getting long path: %v", err)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	previous, existed, err := smt.Swap([]byte("testKey"), []byte("testValue"))
+	if err != nil {
+		t.Errorf("returned error when swapping absent key: %v", err)
+	}
+	if existed || !bytes.Equal(previous, defaultValue) {
+		t.Error("did not return default value for absent key")
+	}
+
+	previous, existed, err = smt.Swap([]byte("testKey"), []byte("testValue2"))
+	if err != nil {
+		t.Errorf("returned error when swapping key: %v", err)
+	}
+	if !existed || !bytes.Equal(previous, []byte("testValue")) {
+		t.Error("did not return previous value of key")
+	}
+	value, _ := smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("testValue2")) {
+		t.Error("did not set new value of key")
+	}
+
+	previous, existed, err = smt.Swap([]byte("testKey"), defaultValue)
+	if err != nil {
+		t.Errorf("returned error when swapping key for default value: %v", err)
+	}
+	if !existed || !bytes.Equal(previous, []byte("testValue2")) {
+		t.Error("did not return previous value of deleted key")
+	}
+	has, _ := smt.Has([]byte("testKey"))
+	if has {
+		t.Error("key was not deleted by swapping it for default value")
+	}
+}
+
+func TestSparseMerkleTreeEphemeral(t *testing.T) {
+	smt := NewSparseMerkleTree(nil, nil, sha256.New(), WithEphemeral())
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+		expected.Update([]byte(s), []byte(s))
+	}
+	smt.Delete([]byte("3"))
+	expected.Delete([]byte("3"))
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root of ephemeral tree does not match")
+	}
+
+	value, err := smt.Get([]byte("4"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("4")) {
+		t.Error("did not get correct value from ephemeral tree")
+	}
+	proof, err := smt.Prove([]byte("4"))
+	if err != nil {
+		t.Errorf("returned error when proving key: %v", err)
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("4"), []byte("4"), sha256.New()) {
+		t.Error("proof from ephemeral tree failed to verify")
+	}
+
+	// Orphaned nodes are removed from memory.
+	if len(smt.nodes.(*SimpleMap).m) != len(expected.nodes.(*SimpleMap).m) {
+		t.Error("ephemeral tree retained orphaned nodes")
+	}
+}
+
+func TestSparseMerkleTreeReplaceRoot(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithUndo(10))
+	other := NewSparseMerkleTree(smn, smv, sha256.New())
+	root, _ := other.Update([]byte("testKey"), []byte("testValue"))
+
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	if err := smt.ReplaceRoot(root); err != nil {
+		t.Errorf("returned error when replacing root: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("root was not replaced")
+	}
+	if err := smt.Undo(); err != ErrNothingToUndo {
+		t.Errorf("did not clear operations to undo: %v", err)
+	}
+	value, _ := smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get value at replaced root")
+	}
+
+	var invalidKeyError *InvalidKeyError
+	if err := smt.ReplaceRoot(bytes.Repeat([]byte{1}, sha256.Size)); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError for missing root: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("root was replaced by missing root")
+	}
+	if err := smt.ReplaceRoot(nil); err != nil {
+		t.Errorf("returned error when replacing root with empty root: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), smt.th.placeholder()) {
+		t.Error("root was not replaced by empty root")
+	}
+}
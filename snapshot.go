@@ -0,0 +1,103 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrSnapshotExpired is returned when reading a snapshot whose nodes or values
+// have since been removed or overwritten by updates of the tree.
+var ErrSnapshotExpired = errors.New("snapshot expired")
+
+// Snapshot is a read-only view of a tree at the root it had when the snapshot
+// was taken. It reads the tree's stores, so it remains usable only as long as
+// the nodes and values of that root are in them: updates of the tree remove
+// orphaned nodes, unless it was created with WithNoOrphanTracking, and
+// overwrite the values of updated keys. Reads that would observe such changes
+// return ErrSnapshotExpired rather than a wrong result. Use Clone for a view
+// that is unaffected by updates.
+type Snapshot struct {
+	smt  *SparseMerkleTree
+	root []byte
+}
+
+// Snapshot returns a read-only view of the tree at its current root.
+func (smt *SparseMerkleTree) Snapshot() *Snapshot {
+	return &Snapshot{smt: smt, root: smt.root}
+}
+
+// expired translates the error of a read of a missing node or value.
+func (s *Snapshot) expired(err error) error {
+	var invalidKeyError *InvalidKeyError
+	if errors.As(err, &invalidKeyError) {
+		return ErrSnapshotExpired
+	}
+	return err
+}
+
+// Root returns the root of the tree when the snapshot was taken.
+func (s *Snapshot) Root() []byte {
+	if s.smt.emptyRootNil && bytes.Equal(s.root, s.smt.th.placeholder()) {
+		return nil
+	}
+	return s.root
+}
+
+// Get gets the value of a key at the root of the snapshot. Unlike the tree's
+// Get, it descends the tree to the key's leaf, and checks that the value in
+// the values MapStore is the one committed to by the leaf.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	smt := s.smt
+	path := smt.th.path(key)
+	_, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, s.root, false)
+	if err != nil {
+		return nil, s.expired(err)
+	}
+	if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		return smt.th.copyDefaultValue(), nil
+	}
+	actualPath, valueHash, err := smt.th.parseLeaf(leafData)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(actualPath, path) {
+		return smt.th.copyDefaultValue(), nil
+	}
+	if smt.isTombstone(valueHash) {
+		return nil, ErrKeyDeleted
+	}
+
+	value, err := smt.values.Get(path)
+	if err != nil {
+		return nil, s.expired(err)
+	}
+	if !bytes.Equal(smt.th.digest(value), valueHash) {
+		// The value was overwritten since the snapshot was taken.
+		return nil, ErrSnapshotExpired
+	}
+	if smt.versionedLeaves {
+		if value, _, err = splitVersion(value); err != nil {
+			return nil, err
+		}
+	}
+	if smt.copyValues {
+		value = append([]byte(nil), value...)
+	}
+	return value, nil
+}
+
+// Has returns true if the value of a key at the root of the snapshot is
+// non-default, false otherwise.
+func (s *Snapshot) Has(key []byte) (bool, error) {
+	value, err := s.Get(key)
+	return !bytes.Equal(s.smt.th.defaultValue, value), err
+}
+
+// Prove generates a Merkle proof for a key against the root of the snapshot.
+func (s *Snapshot) Prove(key []byte) (SparseMerkleProof, error) {
+	proof, err := s.smt.ProveForRoot(key, s.root)
+	if err != nil {
+		return SparseMerkleProof{}, s.expired(err)
+	}
+	return proof, nil
+}
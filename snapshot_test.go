@@ -0,0 +1,69 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	snapshot := smt.Snapshot()
+	root := smt.Root()
+
+	// New keys do not affect the snapshot.
+	smt.Update([]byte("new"), []byte("new"))
+	if !bytes.Equal(snapshot.Root(), root) {
+		t.Error("snapshot root changed")
+	}
+	value, err := snapshot.Get([]byte("3"))
+	if err != nil {
+		t.Errorf("returned error when getting key from snapshot: %v", err)
+	}
+	if !bytes.Equal(value, []byte("3")) {
+		t.Error("did not get correct value from snapshot")
+	}
+	has, err := snapshot.Has([]byte("new"))
+	if err != nil {
+		t.Errorf("returned error when checking key in snapshot: %v", err)
+	}
+	if has {
+		t.Error("key added after snapshot is present in snapshot")
+	}
+	proof, err := snapshot.Prove([]byte("3"))
+	if err != nil {
+		t.Errorf("returned error when proving key in snapshot: %v", err)
+	}
+	if !VerifyProof(proof, root, []byte("3"), []byte("3"), sha256.New()) {
+		t.Error("proof from snapshot failed to verify")
+	}
+
+	// An overwritten value expires the snapshot.
+	smt.Update([]byte("3"), []byte("changed"))
+	if _, err := snapshot.Get([]byte("3")); err != ErrSnapshotExpired {
+		t.Errorf("did not return ErrSnapshotExpired for overwritten value: %v", err)
+	}
+}
+
+func TestSnapshotOrphansRemoved(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	snapshot := smt.Snapshot()
+	for i := 0; i < 20; i++ {
+		smt.Delete([]byte(strconv.Itoa(i)))
+	}
+	if _, err := snapshot.Get([]byte("3")); err != ErrSnapshotExpired {
+		t.Errorf("did not return ErrSnapshotExpired when getting key: %v", err)
+	}
+	if _, err := snapshot.Prove([]byte("3")); err != ErrSnapshotExpired {
+		t.Errorf("did not return ErrSnapshotExpired when proving key: %v", err)
+	}
+}
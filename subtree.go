@@ -0,0 +1,454 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrInvalidPrefix is returned when a prefix is shorter than its number of
+// bits, or longer than the depth of the tree.
+var ErrInvalidPrefix = errors.New("invalid prefix")
+
+func (smt *SparseMerkleTree) checkPrefix(prefix []byte, prefixBits int) error {
+	if prefixBits < 0 || prefixBits > smt.depth() || prefixBits > len(prefix)*8 {
+		return ErrInvalidPrefix
+	}
+	return nil
+}
+
+// subtreeRoot returns the digest of the node rooting the subtree of all paths
+// that begin with the first prefixBits bits of prefix. If a leaf is reached
+// before the prefix is consumed, it is returned only if its path has the
+// prefix; otherwise the subtree is empty and the placeholder is returned.
+func (smt *SparseMerkleTree) subtreeRoot(root []byte, prefix []byte, prefixBits int) ([]byte, error) {
+	currentHash := root
+	for i := 0; i < prefixBits; i++ {
+		if bytes.Equal(currentHash, smt.th.placeholder()) {
+			return currentHash, nil
+		}
+		currentData, err := smt.getNode(currentHash)
+		if err != nil {
+			return nil, err
+		}
+		if smt.th.isLeaf(currentData) {
+			path, _, err := smt.th.parseLeaf(currentData)
+			if err != nil {
+				return nil, err
+			}
+			if hasPrefixBits(path, prefix, prefixBits) {
+				return currentHash, nil
+			}
+			return smt.th.placeholder(), nil
+		}
+
+		leftNode, rightNode := smt.th.parseNode(currentData)
+		if getBitAtFromMSB(prefix, i) == right {
+			currentHash = rightNode
+		} else {
+			currentHash = leftNode
+		}
+	}
+	return currentHash, nil
+}
+
+// walkNodes calls fn for each node in the subtree rooted at root, which is at
+// the given depth, in depth-first, left-to-right order. Placeholders are
+// skipped.
+func (smt *SparseMerkleTree) walkNodes(root []byte, depth int, fn func(hash, data []byte) error) error {
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return nil
+	}
+	if depth > smt.depth() {
+		return ErrCycleDetected
+	}
+	data, err := smt.getNode(root)
+	if err != nil {
+		return err
+	}
+	if err := fn(root, data); err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		return nil
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.walkNodes(leftNode, depth+1, fn); err != nil {
+		return err
+	}
+	return smt.walkNodes(rightNode, depth+1, fn)
+}
+
+// maxLeafDepth returns the depth of the deepest leaf in the subtree rooted at
+// root, which is at the given depth, or the given depth if it is empty.
+func (smt *SparseMerkleTree) maxLeafDepth(root []byte, depth int) (int, error) {
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return depth, nil
+	}
+	data, err := smt.getNode(root)
+	if err != nil {
+		return 0, err
+	}
+	if smt.th.isLeaf(data) {
+		return depth, nil
+	}
+	if depth >= smt.depth() {
+		return 0, ErrCycleDetected
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	leftDepth, err := smt.maxLeafDepth(leftNode, depth+1)
+	if err != nil {
+		return 0, err
+	}
+	rightDepth, err := smt.maxLeafDepth(rightNode, depth+1)
+	if err != nil {
+		return 0, err
+	}
+	if rightDepth > leftDepth {
+		return rightDepth, nil
+	}
+	return leftDepth, nil
+}
+
+// MaxDepth returns the depth of the deepest leaf of the tree, which is the
+// number of side nodes in the longest proof of the tree, or 0 if the tree has
+// at most one leaf. It is maintained by updates that insert keys; after a key
+// is deleted or the root is set by other means, e.g. ImportSparseMerkleTree,
+// it is computed again by walking the tree on the next call.
+func (smt *SparseMerkleTree) MaxDepth() (int, error) {
+	if bytes.Equal(smt.root, smt.maxDepthRoot) {
+		return smt.maxDepth, nil
+	}
+	maxDepth, err := smt.maxLeafDepth(smt.root, 0)
+	if err != nil {
+		return 0, err
+	}
+	smt.maxDepth = maxDepth
+	smt.maxDepthRoot = smt.root
+	return maxDepth, nil
+}
+
+// SubtreeNodeCount returns the number of distinct nodes persisted in the
+// subtree of all paths that begin with the first prefixBits bits of prefix,
+// without modifying the tree. This is the number of nodes that would be
+// removed from the nodes MapStore if the subtree were deleted.
+func (smt *SparseMerkleTree) SubtreeNodeCount(prefix []byte, prefixBits int) (int, error) {
+	if err := smt.checkPrefix(prefix, prefixBits); err != nil {
+		return 0, err
+	}
+	subtreeRoot, err := smt.subtreeRoot(smt.root, prefix, prefixBits)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]struct{})
+	err = smt.walkNodes(subtreeRoot, prefixBits, func(hash, data []byte) error {
+		seen[string(hash)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(seen), nil
+}
+
+// CompactTo copies the nodes reachable from the root of the tree to dst, which
+// is typically an empty MapStore, and returns the root. This reclaims the
+// space of orphaned nodes left in the nodes MapStore by a tree created with
+// WithNoOrphanTracking: a tree imported from dst at the returned root is the
+// same tree. If the nodes and values MapStores are the same, the values of the
+// leaves are copied too, so dst can replace it.
+func (smt *SparseMerkleTree) CompactTo(dst MapStore) ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	copyValues := smt.values == smt.nodes
+	err := smt.walkNodes(smt.root, 0, func(hash, data []byte) error {
+		if err := dst.Set(hash, data); err != nil {
+			return err
+		}
+		if !copyValues || !smt.th.isLeaf(data) {
+			return nil
+		}
+		path, valueHash, err := smt.th.parseLeaf(data)
+		if err != nil {
+			return err
+		}
+		if smt.isTombstone(valueHash) {
+			return nil
+		}
+		value, err := smt.values.Get(path)
+		if err != nil {
+			return err
+		}
+		return dst.Set(path, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return smt.Root(), nil
+}
+
+// StoreChecksum returns a checksum of the nodes reachable from the root of the
+// tree, computed by reading each of them from the nodes MapStore, in a
+// canonical order. Stores holding the same tree, e.g. replicas, have the same
+// checksum, whatever orphaned nodes they also hold; a store with a missing
+// node returns an error, and one with a corrupt node a different checksum.
+func (smt *SparseMerkleTree) StoreChecksum() ([]byte, error) {
+	// The nodes are chained into the checksum one at a time, as reading a
+	// node may use the hasher.
+	checksum := smt.th.digest(smt.root)
+	err := smt.walkNodes(smt.root, 0, func(hash, data []byte) error {
+		record := make([]byte, 0, len(checksum)+len(hash)+len(data))
+		record = append(append(append(record, checksum...), hash...), data...)
+		checksum = smt.th.digest(record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// KeysWithPrefix returns the leaves of the subtree of all paths that begin with
+// the first prefixBits bits of prefix, in path order, without iterating over
+// the rest of the tree. Since the tree stores paths rather than keys, the
+// leaves are identified by their paths, which are hashes of the keys; callers
+// that need the original keys must keep a mapping from Path to key.
+func (smt *SparseMerkleTree) KeysWithPrefix(prefix []byte, prefixBits int) ([]LeafEntry, error) {
+	if err := smt.checkPrefix(prefix, prefixBits); err != nil {
+		return nil, err
+	}
+	subtreeRoot, err := smt.subtreeRoot(smt.root, prefix, prefixBits)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaves []LeafEntry
+	err = smt.forEachLeaf(subtreeRoot, prefixBits, nil, func(path, valueHash []byte) error {
+		if smt.isTombstone(valueHash) {
+			return nil
+		}
+		value, err := smt.leafValue(path)
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, LeafEntry{Path: path, Value: value})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
+
+// ErrTreeNotEmpty is returned when an operation requires an empty tree.
+var ErrTreeNotEmpty = errors.New("tree is not empty")
+
+// prefixForIndex returns a path whose first prefixBits bits are the binary
+// representation of index.
+func (smt *SparseMerkleTree) prefixForIndex(index int, prefixBits int) []byte {
+	prefix := make([]byte, smt.th.pathSize())
+	for i := 0; i < prefixBits; i++ {
+		if (index>>uint(prefixBits-1-i))&1 == 1 {
+			setBitAtFromMSB(prefix, i)
+		}
+	}
+	return prefix
+}
+
+// mergeNodes returns the parent of two sibling nodes, applying the same rule
+// as updates and deletions: a leaf with a placeholder sibling takes the place
+// of its parent. The parent is at the given depth.
+func (smt *SparseMerkleTree) mergeNodes(left, right []byte, leftIsLeaf, rightIsLeaf bool, depth int) ([]byte, bool, error) {
+	leftEmpty := bytes.Equal(left, smt.th.placeholder())
+	rightEmpty := bytes.Equal(right, smt.th.placeholder())
+	if leftEmpty && (rightEmpty || rightIsLeaf) {
+		return right, rightIsLeaf, nil
+	}
+	if rightEmpty && leftIsLeaf {
+		return left, true, nil
+	}
+	digest, data := smt.th.digestNode(left, right, depth)
+	if err := smt.setNode(digest, data); err != nil {
+		return nil, false, err
+	}
+	return digest, false, nil
+}
+
+// Merge sets the root of an empty tree to the combination of several
+// subtrees, built independently, for example concurrently. The subtree at
+// index i of subtrees must only contain keys whose paths begin with the
+// prefixBits-bit binary representation of i, so the subtrees are disjoint;
+// otherwise ErrInvalidPrefix is returned. The subtrees must use the same
+// hasher and options as the tree. Their nodes and values are copied to the
+// tree's stores, and the resulting root is the same as if all their keys had
+// been updated in the tree.
+func (smt *SparseMerkleTree) Merge(prefixBits int, subtrees map[int]*SparseMerkleTree) error {
+	if err := smt.merge(prefixBits, subtrees); err != nil {
+		smt.discardNodes()
+		return err
+	}
+	return nil
+}
+
+func (smt *SparseMerkleTree) merge(prefixBits int, subtrees map[int]*SparseMerkleTree) error {
+	if prefixBits < 0 || prefixBits > smt.depth() || prefixBits >= 32 {
+		return ErrInvalidPrefix
+	}
+	if !bytes.Equal(smt.root, smt.th.placeholder()) {
+		return ErrTreeNotEmpty
+	}
+
+	level := make(map[int][]byte)
+	isLeaf := make(map[int]bool)
+	for index, subtree := range subtrees {
+		if index < 0 || index >= 1<<uint(prefixBits) {
+			return ErrInvalidPrefix
+		}
+		prefix := smt.prefixForIndex(index, prefixBits)
+		node, err := subtree.subtreeRoot(subtree.root, prefix, prefixBits)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(node, smt.th.placeholder()) {
+			if !bytes.Equal(subtree.root, smt.th.placeholder()) {
+				return ErrInvalidPrefix
+			}
+			continue
+		}
+		data, err := subtree.getNode(node)
+		if err != nil {
+			return err
+		}
+		isLeaf[index] = subtree.th.isLeaf(data)
+
+		// Check that the subtree has no keys outside of its prefix, by
+		// rebuilding its root from the node at the prefix.
+		expectedRoot := node
+		if !isLeaf[index] {
+			for i := prefixBits - 1; i >= 0; i-- {
+				if getBitAtFromMSB(prefix, i) == right {
+					expectedRoot, _ = smt.th.digestNode(smt.th.placeholder(), expectedRoot, i)
+				} else {
+					expectedRoot, _ = smt.th.digestNode(expectedRoot, smt.th.placeholder(), i)
+				}
+			}
+		}
+		if !bytes.Equal(expectedRoot, subtree.root) {
+			return ErrInvalidPrefix
+		}
+
+		err = subtree.walkNodes(node, prefixBits, func(hash, data []byte) error {
+			if err := smt.setNode(hash, data); err != nil {
+				return err
+			}
+			if !subtree.th.isLeaf(data) {
+				return nil
+			}
+			path, _, err := subtree.th.parseLeaf(data)
+			if err != nil {
+				return err
+			}
+			value, err := subtree.values.Get(path)
+			if err != nil {
+				return err
+			}
+			return smt.values.Set(path, value)
+		})
+		if err != nil {
+			return err
+		}
+		level[index] = node
+	}
+
+	// Build the top levels of the tree from the roots of the subtrees.
+	for bits := prefixBits; bits > 0; bits-- {
+		parents := make(map[int][]byte)
+		parentIsLeaf := make(map[int]bool)
+		for index := range level {
+			parentIndex := index >> 1
+			if _, ok := parents[parentIndex]; ok {
+				continue
+			}
+			leftNode, ok := level[parentIndex<<1]
+			if !ok {
+				leftNode = smt.th.placeholder()
+			}
+			rightNode, ok := level[parentIndex<<1|1]
+			if !ok {
+				rightNode = smt.th.placeholder()
+			}
+			parent, leaf, err := smt.mergeNodes(leftNode, rightNode, isLeaf[parentIndex<<1], isLeaf[parentIndex<<1|1], bits-1)
+			if err != nil {
+				return err
+			}
+			parents[parentIndex], parentIsLeaf[parentIndex] = parent, leaf
+		}
+		level, isLeaf = parents, parentIsLeaf
+	}
+
+	if err := smt.commitNodes(); err != nil {
+		return err
+	}
+	if root, ok := level[0]; ok {
+		smt.root = root
+	}
+	return nil
+}
+
+// ErrNotDisjoint is returned by Union when both trees have a key with the same
+// path.
+var ErrNotDisjoint = errors.New("trees are not disjoint")
+
+// Union inserts the keys of tree b into tree a, and returns a, e.g. to combine
+// shards built independently over disjoint sets of keys. ErrNotDisjoint is
+// returned, and a is not modified, if the trees have a key with the same path.
+// The trees must use the same hasher and options, and the resulting root is
+// the same as if all the keys had been updated in one tree. The insertions
+// are not recorded by WithWAL, WithUndo or WithRootLog.
+func Union(a, b *SparseMerkleTree) (*SparseMerkleTree, error) {
+	var entries []LeafEntry
+	err := b.forEachLeaf(b.root, 0, nil, func(path, valueHash []byte) error {
+		if b.isTombstone(valueHash) {
+			return nil
+		}
+		_, _, leafData, _, err := a.sideNodesForRoot(path, a.root, false)
+		if err != nil {
+			return err
+		}
+		if leafData != nil {
+			actualPath, valueHash, err := a.th.parseLeaf(leafData)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(actualPath, path) && !a.isTombstone(valueHash) {
+				return ErrNotDisjoint
+			}
+		}
+		value, err := b.values.Get(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, LeafEntry{Path: path, Value: value})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	root := a.root
+	for _, entry := range entries {
+		root, err = a.updatePathForRoot(entry.Path, entry.Value, a.th.digest(entry.Value), root)
+		if err == nil {
+			// Later keys are inserted from the nodes written for earlier ones.
+			err = a.commitNodes()
+		}
+		if err != nil {
+			a.discardNodes()
+			return nil, err
+		}
+	}
+	a.SetRoot(root)
+	a.undoStack, a.redoStack = nil, nil
+	return a, nil
+}
@@ -0,0 +1,340 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestSubtreeNodeCount(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+
+	count, err := smt.SubtreeNodeCount(nil, 0)
+	if err != nil {
+		t.Errorf("returned error when counting nodes of empty tree: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("expected 0 nodes in empty tree, got: %d", count)
+	}
+
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+
+	count, err = smt.SubtreeNodeCount(nil, 0)
+	if err != nil {
+		t.Errorf("returned error when counting nodes: %v", err)
+	}
+	if count != len(smn.m) {
+		t.Errorf("expected %d nodes in tree, got: %d", len(smn.m), count)
+	}
+
+	leftCount, err := smt.SubtreeNodeCount([]byte{0b00000000}, 1)
+	if err != nil {
+		t.Errorf("returned error when counting nodes: %v", err)
+	}
+	rightCount, err := smt.SubtreeNodeCount([]byte{0b10000000}, 1)
+	if err != nil {
+		t.Errorf("returned error when counting nodes: %v", err)
+	}
+	if leftCount+rightCount+1 != count {
+		t.Errorf("expected subtree node counts to sum to %d, got: %d", count-1, leftCount+rightCount)
+	}
+	if len(smn.m) != count {
+		t.Error("counting nodes modified the nodes MapStore")
+	}
+
+	_, err = smt.SubtreeNodeCount([]byte{0}, 9)
+	if err != ErrInvalidPrefix {
+		t.Error("did not return ErrInvalidPrefix for a prefix shorter than its number of bits")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for _, numKeys := range []int{0, 1, 2, 50} {
+		for _, prefixBits := range []int{0, 1, 3} {
+			full := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+			subtrees := make(map[int]*SparseMerkleTree)
+			for i := 0; i < numKeys; i++ {
+				s := strconv.Itoa(i)
+				full.Update([]byte(s), []byte(s))
+
+				path := full.th.path([]byte(s))
+				index := int(path[0]) >> uint(8-prefixBits)
+				if subtrees[index] == nil {
+					subtrees[index] = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+				}
+				subtrees[index].Update([]byte(s), []byte(s))
+			}
+
+			smn, smv := NewSimpleMap(), NewSimpleMap()
+			merged := NewSparseMerkleTree(smn, smv, sha256.New())
+			if err := merged.Merge(prefixBits, subtrees); err != nil {
+				t.Errorf("returned error when merging subtrees: %v", err)
+			}
+			if !bytes.Equal(merged.Root(), full.Root()) {
+				t.Errorf("merged root does not match for %d keys and %d prefix bits", numKeys, prefixBits)
+			}
+			if len(smn.m) != len(full.nodes.(*SimpleMap).m) || len(smv.m) != numKeys {
+				t.Error("merged tree does not have the expected nodes and values")
+			}
+			for i := 0; i < numKeys; i++ {
+				s := strconv.Itoa(i)
+				value, err := merged.Get([]byte(s))
+				if err != nil || !bytes.Equal(value, []byte(s)) {
+					t.Error("merged tree does not have the expected value")
+				}
+			}
+		}
+	}
+
+	// Subtrees with keys outside of their prefix cannot be merged.
+	subtree := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		s := strconv.Itoa(i)
+		subtree.Update([]byte(s), []byte(s))
+	}
+	merged := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	err := merged.Merge(1, map[int]*SparseMerkleTree{0: subtree})
+	if !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("expected ErrInvalidPrefix when merging invalid subtree, got: %v", err)
+	}
+
+	merged.Update([]byte("testKey"), []byte("testValue"))
+	err = merged.Merge(0, nil)
+	if !errors.Is(err, ErrTreeNotEmpty) {
+		t.Errorf("expected ErrTreeNotEmpty when merging into non-empty tree, got: %v", err)
+	}
+}
+
+func TestKeysWithPrefix(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	leaves, err := smt.KeysWithPrefix([]byte{0b10100000}, 3)
+	if err != nil || len(leaves) != 0 {
+		t.Error("did not get no leaves from empty tree")
+	}
+
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	all, _, _ := smt.IterateFrom(nil, 0)
+
+	for _, prefixBits := range []int{0, 1, 3, 6, 256} {
+		prefix := smt.Path([]byte("7"))
+		leaves, err := smt.KeysWithPrefix(prefix, prefixBits)
+		if err != nil {
+			t.Fatalf("returned error when listing keys with prefix: %v", err)
+		}
+		var expected []LeafEntry
+		for _, leaf := range all {
+			if hasPrefixBits(leaf.Path, prefix, prefixBits) {
+				expected = append(expected, leaf)
+			}
+		}
+		if len(leaves) != len(expected) {
+			t.Fatalf("expected %d leaves with %d-bit prefix, got: %d", len(expected), prefixBits, len(leaves))
+		}
+		for i := range leaves {
+			if !bytes.Equal(leaves[i].Path, expected[i].Path) || !bytes.Equal(leaves[i].Value, expected[i].Value) {
+				t.Error("leaves with prefix do not match")
+			}
+		}
+	}
+
+	if _, err := smt.KeysWithPrefix([]byte{0}, 9); err != ErrInvalidPrefix {
+		t.Error("did not return ErrInvalidPrefix for a prefix shorter than its number of bits")
+	}
+}
+
+func TestCompactTo(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		smn := NewSimpleMap()
+		smv := NewSimpleMap()
+		if shared {
+			smv = smn
+		}
+		smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithNoOrphanTracking())
+		for i := 0; i < 50; i++ {
+			s := strconv.Itoa(i)
+			smt.Update([]byte(s), []byte(s))
+			smt.Update([]byte(s), []byte(s+"updated"))
+		}
+
+		dst := NewSimpleMap()
+		root, err := smt.CompactTo(dst)
+		if err != nil {
+			t.Fatalf("returned error when compacting: %v", err)
+		}
+		if !bytes.Equal(root, smt.Root()) {
+			t.Error("compacted root does not match")
+		}
+		nodeCount, _ := smt.SubtreeNodeCount(nil, 0)
+		expectedSize := nodeCount
+		if shared {
+			expectedSize += 50
+		}
+		if len(dst.m) != expectedSize {
+			t.Errorf("expected %d entries in compacted store, got: %d", expectedSize, len(dst.m))
+		}
+		if len(dst.m) >= len(smn.m) {
+			t.Error("compacted store is not smaller")
+		}
+
+		values := smv
+		if shared {
+			values = dst
+		}
+		compacted := ImportSparseMerkleTree(dst, values, sha256.New(), root)
+		if err := compacted.ValidateAgainstStore(); err != nil {
+			t.Errorf("compacted tree is inconsistent: %v", err)
+		}
+		for i := 0; i < 50; i++ {
+			s := strconv.Itoa(i)
+			value, err := compacted.Get([]byte(s))
+			if err != nil || !bytes.Equal(value, []byte(s+"updated")) {
+				t.Error("got incorrect value from compacted tree")
+			}
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+
+	checkMaxDepth := func(tree *SparseMerkleTree, keys int) {
+		t.Helper()
+		expected := 0
+		for i := 0; i < keys; i++ {
+			proof, err := tree.Prove([]byte("key" + strconv.Itoa(i)))
+			if err != nil {
+				t.Fatalf("returned error when proving key: %v", err)
+			}
+			if len(proof.SideNodes) > expected {
+				expected = len(proof.SideNodes)
+			}
+		}
+		maxDepth, err := tree.MaxDepth()
+		if err != nil {
+			t.Fatalf("returned error when getting max depth: %v", err)
+		}
+		if maxDepth != expected {
+			t.Errorf("expected max depth %d, got %d", expected, maxDepth)
+		}
+	}
+
+	checkMaxDepth(smt, 0)
+	for i := 0; i < 100; i++ {
+		if _, err := smt.Update([]byte("key"+strconv.Itoa(i)), []byte("value")); err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+		if i%10 == 0 {
+			checkMaxDepth(smt, i+1)
+		}
+	}
+	checkMaxDepth(smt, 100)
+	if !bytes.Equal(smt.maxDepthRoot, smt.root) {
+		t.Error("expected max depth to be maintained by updates")
+	}
+
+	for i := 50; i < 100; i++ {
+		if _, err := smt.Delete([]byte("key" + strconv.Itoa(i))); err != nil {
+			t.Fatalf("returned error when deleting key: %v", err)
+		}
+	}
+	checkMaxDepth(smt, 50)
+
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), smt.Root())
+	checkMaxDepth(imported, 50)
+}
+
+func TestUnion(t *testing.T) {
+	a := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	b := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		if i%3 == 0 {
+			a.Update([]byte(s), []byte(s))
+		} else {
+			b.Update([]byte(s), []byte(s))
+		}
+		expected.Update([]byte(s), []byte(s))
+	}
+
+	union, err := Union(a, b)
+	if err != nil {
+		t.Fatalf("returned error when uniting trees: %v", err)
+	}
+	if union != a {
+		t.Error("did not return first tree")
+	}
+	if !bytes.Equal(union.Root(), expected.Root()) {
+		t.Error("root of union does not match root of tree with all keys")
+	}
+	for i := 0; i < 50; i++ {
+		s := strconv.Itoa(i)
+		value, err := union.Get([]byte(s))
+		if err != nil {
+			t.Fatalf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, []byte(s)) {
+			t.Errorf("did not get correct value for key %s", s)
+		}
+	}
+
+	// Trees sharing a key are rejected, and left unmodified.
+	c := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	c.Update([]byte("new"), []byte("new"))
+	c.Update([]byte("7"), []byte("other"))
+	root := a.Root()
+	if _, err := Union(a, c); err != ErrNotDisjoint {
+		t.Errorf("did not return ErrNotDisjoint for overlapping trees: %v", err)
+	}
+	if !bytes.Equal(a.Root(), root) {
+		t.Error("tree was modified by failed union")
+	}
+}
+
+func TestStoreChecksum(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	replica := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		s := []byte(strconv.Itoa(i))
+		smt.Update(s, []byte("old"))
+		smt.Update(s, s)
+		replica.Update(s, s)
+	}
+
+	// The orphans left in the first store do not change the checksum.
+	checksum, err := smt.StoreChecksum()
+	if err != nil {
+		t.Fatalf("returned error when computing checksum: %v", err)
+	}
+	replicaChecksum, err := replica.StoreChecksum()
+	if err != nil {
+		t.Fatalf("returned error when computing checksum of replica: %v", err)
+	}
+	if !bytes.Equal(checksum, replicaChecksum) {
+		t.Error("checksums of replicas do not match")
+	}
+
+	// A corrupt node is detected, by a different checksum or an error.
+	smt.Update([]byte("new"), []byte("new"))
+	replica.Update([]byte("new"), []byte("new"))
+	replicaChecksum, _ = replica.StoreChecksum()
+	root := smt.Root()
+	data := append([]byte(nil), smn.m[string(root)]...)
+	data[len(data)-1] ^= 1
+	smn.m[string(root)] = data
+	corrupted, err := smt.StoreChecksum()
+	if err == nil && bytes.Equal(corrupted, replicaChecksum) {
+		t.Error("checksum of corrupt store matches replica")
+	}
+}
@@ -0,0 +1,98 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// ErrKeyDeleted is returned when getting a key that was deleted from a tree
+// with tombstones.
+var ErrKeyDeleted = errors.New("key deleted")
+
+// isTombstone returns true if a leaf's value hash is the tombstone of a
+// deleted key. The tombstone is all zeros, which no value hashes to.
+func (smt *SparseMerkleTree) isTombstone(valueHash []byte) bool {
+	return smt.tombstones && bytes.Equal(valueHash, smt.th.placeholder())
+}
+
+// checkTombstone returns ErrKeyDeleted if the leaf of a path is a tombstone.
+func (smt *SparseMerkleTree) checkTombstone(path []byte) error {
+	_, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		return nil
+	}
+	actualPath, valueHash, err := smt.th.parseLeaf(leafData)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(actualPath, path) && smt.isTombstone(valueHash) {
+		return ErrKeyDeleted
+	}
+	return nil
+}
+
+// Purge removes a key from the tree, along with its tombstone if it was
+// deleted, and sets and returns the new root of the tree.
+func (smt *SparseMerkleTree) Purge(key []byte) ([]byte, error) {
+	if smt.closed {
+		return nil, ErrClosed
+	}
+	if smt.noOrphanTracking {
+		return nil, ErrNoOrphanTracking
+	}
+	if err := smt.writeWALPurge(key); err != nil {
+		return nil, err
+	}
+	newRoot, err := smt.purgePath(smt.th.path(key))
+	if err == nil {
+		err = smt.commitNodes()
+	}
+	if err != nil {
+		smt.discardNodes()
+		return nil, err
+	}
+	oldRoot := smt.root
+	smt.SetRoot(newRoot)
+	if err := smt.logRoot(oldRoot); err != nil {
+		return nil, err
+	}
+	return smt.Root(), nil
+}
+
+// purgePath removes the leaf of a path, and its value unless it is a
+// tombstone, and returns the new root, without committing the batch.
+func (smt *SparseMerkleTree) purgePath(path []byte) ([]byte, error) {
+	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(path, smt.root, false)
+	if err != nil {
+		return nil, err
+	}
+	newRoot, err := smt.deleteWithSideNodes(path, sideNodes, pathNodes, oldLeafData)
+	if errors.Is(err, errKeyAlreadyEmpty) {
+		return smt.root, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, oldValueHash, err := smt.th.parseLeaf(oldLeafData)
+	if err != nil {
+		return nil, err
+	}
+	if !smt.isTombstone(oldValueHash) {
+		if err := smt.values.Delete(path); err != nil {
+			return nil, err
+		}
+	}
+	return newRoot, nil
+}
+
+// VerifyTombstoneProof verifies a Merkle proof that a key was deleted from a
+// tree with tombstones, i.e. a membership proof of its tombstone.
+func VerifyTombstoneProof(proof SparseMerkleProof, root []byte, key []byte, hasher hash.Hash, options ...Option) bool {
+	th := newVerifierTreeHasher(hasher, options)
+	result, _ := verifyProofForPath(proof, root, 0, th.path(key), th.placeholder(), th)
+	return result
+}
@@ -0,0 +1,154 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestTombstones(t *testing.T) {
+	smv := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), smv, sha256.New(), WithTombstones())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	root := smt.Root()
+
+	root2, err := smt.Delete([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when deleting key: %v", err)
+	}
+	if bytes.Equal(root, root2) {
+		t.Error("deleting key did not change root")
+	}
+	if len(smv.m) != 1 {
+		t.Error("value of deleted key was not removed")
+	}
+	if _, err := smt.Get([]byte("testKey")); !errors.Is(err, ErrKeyDeleted) {
+		t.Errorf("expected ErrKeyDeleted when getting deleted key, got: %v", err)
+	}
+	value, err := smt.Get([]byte("absent"))
+	if err != nil || !bytes.Equal(value, defaultValue) {
+		t.Error("did not get default value when getting absent key")
+	}
+
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyTombstoneProof(proof, smt.Root(), []byte("testKey"), sha256.New()) {
+		t.Error("valid tombstone proof failed to verify")
+	}
+	if VerifyProof(proof, smt.Root(), []byte("testKey"), defaultValue, sha256.New()) {
+		t.Error("tombstone proof verified as non-membership proof")
+	}
+	proof, _ = smt.Prove([]byte("testKey2"))
+	if VerifyTombstoneProof(proof, smt.Root(), []byte("testKey2"), sha256.New()) {
+		t.Error("tombstone proof verified for live key")
+	}
+
+	// Deleting again, or deleting an absent key, changes nothing.
+	if root3, _ := smt.Delete([]byte("testKey")); !bytes.Equal(root3, root2) {
+		t.Error("deleting deleted key changed root")
+	}
+	if root3, _ := smt.Delete([]byte("absent")); !bytes.Equal(root3, root2) {
+		t.Error("deleting absent key changed root")
+	}
+
+	// A deleted key can be set again.
+	root3, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+	if !bytes.Equal(root3, root) {
+		t.Error("setting deleted key did not restore root")
+	}
+
+	// Purging a deleted key removes it.
+	smt.Delete([]byte("testKey"))
+	if _, err := smt.Purge([]byte("testKey")); err != nil {
+		t.Errorf("returned error when purging key: %v", err)
+	}
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	expected.Update([]byte("testKey2"), []byte("testValue2"))
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("purging key did not remove it")
+	}
+	value, err = smt.Get([]byte("testKey"))
+	if err != nil || !bytes.Equal(value, defaultValue) {
+		t.Error("did not get default value when getting purged key")
+	}
+}
+
+// newTombstoneTestTree returns a tree with tombstones with keys "0" to "9",
+// of which "3" is deleted.
+func newTombstoneTestTree(t *testing.T) *SparseMerkleTree {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithTombstones())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte{'0' + byte(i)}, []byte("testValue"))
+	}
+	if _, err := smt.Delete([]byte("3")); err != nil {
+		t.Fatalf("returned error when deleting key: %v", err)
+	}
+	return smt
+}
+
+// Test that iterating a tree with tombstones skips them.
+func TestTombstonesIterateFrom(t *testing.T) {
+	smt := newTombstoneTestTree(t)
+	leaves, _, err := smt.IterateFrom(nil, 0)
+	if err != nil {
+		t.Fatalf("returned error when iterating: %v", err)
+	}
+	if len(leaves) != 9 {
+		t.Errorf("expected 9 leaves, got: %d", len(leaves))
+	}
+	for _, leaf := range leaves {
+		if bytes.Equal(leaf.Path, smt.th.path([]byte("3"))) {
+			t.Error("iteration returned the tombstone of a deleted key")
+		}
+	}
+
+	// Paging returns the same leaves.
+	var paged []LeafEntry
+	var cursor []byte
+	for {
+		var page []LeafEntry
+		page, cursor, err = smt.IterateFrom(cursor, 2)
+		if err != nil {
+			t.Fatalf("returned error when iterating: %v", err)
+		}
+		paged = append(paged, page...)
+		if cursor == nil {
+			break
+		}
+	}
+	if len(paged) != len(leaves) {
+		t.Errorf("expected %d leaves when paging, got: %d", len(leaves), len(paged))
+	}
+}
+
+// Test that exporting a tree with tombstones skips them.
+func TestTombstonesExportVerifiable(t *testing.T) {
+	smt := newTombstoneTestTree(t)
+	var buf bytes.Buffer
+	if err := smt.ExportVerifiable(&buf); err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	root, count, err := VerifyExport(&buf, sha256.New(), WithTombstones())
+	if err != nil {
+		t.Fatalf("returned error when verifying export: %v", err)
+	}
+	if !bytes.Equal(root, smt.Root()) || count != 9 {
+		t.Errorf("expected 9 leaves against the root, got: %d", count)
+	}
+}
+
+// Test that LightProof returns ErrKeyDeleted for a deleted key.
+func TestTombstonesLightProof(t *testing.T) {
+	smt := newTombstoneTestTree(t)
+	if _, _, _, err := smt.LightProof([]byte("3")); err != ErrKeyDeleted {
+		t.Errorf("did not return ErrKeyDeleted for deleted key: %v", err)
+	}
+	value, proof, root, err := smt.LightProof([]byte("4"))
+	if err != nil {
+		t.Fatalf("returned error when getting light proof: %v", err)
+	}
+	if !VerifyCompactProof(proof, root, []byte("4"), value, sha256.New()) {
+		t.Error("valid light proof failed to verify")
+	}
+}
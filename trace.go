@@ -0,0 +1,46 @@
+package smt
+
+// TraceOp is the type of an operation traced by WithLogger.
+type TraceOp int
+
+// Operations traced by WithLogger.
+const (
+	TraceGet TraceOp = iota
+	TraceUpdate
+	TraceDelete
+)
+
+// TraceEvent describes an operation on a tree created with WithLogger.
+type TraceEvent struct {
+	Op   TraceOp
+	Key  []byte
+	Path []byte
+	// Depth is the depth at which the traversal of the tree along the path
+	// ended, at the leaf of the key or the node where it would be. Get reads
+	// values by path without traversing the tree, so its depth is 0.
+	Depth int
+	// StoreReads is the number of reads from the nodes and values MapStores
+	// performed by the operation.
+	StoreReads int
+	// Err is the error returned by the operation, if any.
+	Err error
+}
+
+// startTrace starts counting the store reads of an operation.
+func (smt *SparseMerkleTree) startTrace() {
+	smt.tracing = true
+	smt.traceReads, smt.traceDepth = 0, 0
+}
+
+// endTrace passes the event of a traced operation to the logger.
+func (smt *SparseMerkleTree) endTrace(op TraceOp, key []byte, err error) {
+	smt.tracing = false
+	smt.logger(TraceEvent{
+		Op:         op,
+		Key:        key,
+		Path:       smt.th.path(key),
+		Depth:      smt.traceDepth,
+		StoreReads: smt.traceReads,
+		Err:        err,
+	})
+}
@@ -0,0 +1,61 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var events []TraceEvent
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithLogger(func(event TraceEvent) {
+		events = append(events, event)
+	}))
+	for i := 0; i < 20; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	if len(events) != 20 {
+		t.Fatalf("expected 20 events, got %d", len(events))
+	}
+
+	events = nil
+	smt.Update([]byte("3"), []byte("new"))
+	smt.Get([]byte("3"))
+	smt.Delete([]byte("3"))
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	for i, op := range []TraceOp{TraceUpdate, TraceGet, TraceDelete} {
+		event := events[i]
+		if event.Op != op {
+			t.Errorf("expected op %d, got %d", op, event.Op)
+		}
+		if !bytes.Equal(event.Key, []byte("3")) || !bytes.Equal(event.Path, smt.Path([]byte("3"))) {
+			t.Error("event has wrong key or path")
+		}
+		if event.Err != nil {
+			t.Errorf("event has error: %v", event.Err)
+		}
+		if event.StoreReads == 0 {
+			t.Error("event has no store reads")
+		}
+	}
+	if events[0].Depth == 0 || events[0].StoreReads != events[0].Depth+1 {
+		t.Errorf("unexpected depth %d and store reads %d of update", events[0].Depth, events[0].StoreReads)
+	}
+	if events[1].StoreReads != 1 {
+		t.Errorf("expected 1 store read for get, got %d", events[1].StoreReads)
+	}
+
+	events = nil
+	smt.UpdatePreview([]byte("preview"), []byte("preview"))
+	smt.Close()
+	if _, err := smt.Get([]byte("3")); err != ErrClosed {
+		t.Errorf("did not return ErrClosed: %v", err)
+	}
+	if len(events) != 1 || events[0].Err != ErrClosed {
+		t.Error("did not log failed operation only")
+	}
+}
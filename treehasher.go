@@ -2,20 +2,49 @@ package smt
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"hash"
 )
 
-var leafPrefix = []byte{0}
-var nodePrefix = []byte{1}
+// ErrHasherMismatch is returned when the root of a tree does not have the size
+// of the digests of the tree's hasher, e.g. because the tree is imported with
+// another hasher than it was created with.
+var ErrHasherMismatch = errors.New("hasher does not match tree")
+
+// ErrBadLeaf is returned when leaf data does not have the expected size.
+var ErrBadLeaf = errors.New("bad leaf")
+
+// ErrFormatVersionMismatch is returned when reading a node serialized with a
+// format version other than that of the tree.
+var ErrFormatVersionMismatch = errors.New("node format version mismatch")
 
 type treeHasher struct {
 	hasher    hash.Hash
+	codec     NodeCodec
 	zeroValue []byte
+	leafSalt  []byte
+	// leafTag and innerTag are hashed before leaves and inner nodes
+	// respectively, if set.
+	leafTag, innerTag []byte
+	pathLen           int
+	// keyNamespace is prepended to keys before they are hashed, if set.
+	keyNamespace []byte
+	// defaultValue is the value of absent keys.
+	defaultValue []byte
+	// emptyValueDeletes is true if empty values are the same as the default
+	// value.
+	emptyValueDeletes bool
+	// depthBound is true if inner nodes are serialized with their depth.
+	depthBound bool
+	// formatVersion is the version byte prepended to serialized nodes, if
+	// any.
+	formatVersion []byte
 }
 
 func newTreeHasher(hasher hash.Hash) *treeHasher {
-	th := treeHasher{hasher: hasher}
-	th.zeroValue = make([]byte, th.pathSize())
+	th := treeHasher{hasher: hasher, codec: defaultCodec{}, defaultValue: defaultValue}
+	th.zeroValue = make([]byte, th.hasher.Size())
 
 	return &th
 }
@@ -27,16 +56,33 @@ func (th *treeHasher) digest(data []byte) []byte {
 	return sum
 }
 
+// copyDefaultValue returns a copy of the value of absent keys, to be returned
+// to callers, who may modify it.
+func (th *treeHasher) copyDefaultValue() []byte {
+	return append([]byte{}, th.defaultValue...)
+}
+
+// isDefault returns true if setting a key to the value deletes it.
+func (th *treeHasher) isDefault(value []byte) bool {
+	return bytes.Equal(value, th.defaultValue) || (th.emptyValueDeletes && len(value) == 0)
+}
+
 func (th *treeHasher) path(key []byte) []byte {
-	return th.digest(key)
+	if th.keyNamespace != nil {
+		key = NamespacedKey(th.keyNamespace, key)
+	}
+	path := th.digest(key)
+	if th.pathLen > 0 && len(path) > th.pathLen {
+		path = path[:th.pathLen]
+	}
+	return path
 }
 
 func (th *treeHasher) digestLeaf(path []byte, leafData []byte) ([]byte, []byte) {
-	value := make([]byte, 0, len(leafPrefix)+len(path)+len(leafData))
-	value = append(value, leafPrefix...)
-	value = append(value, path...)
-	value = append(value, leafData...)
+	value := th.withFormatVersion(th.codec.EncodeLeaf(path, leafData))
 
+	th.hasher.Write(th.leafTag)
+	th.hasher.Write(th.leafSalt)
 	th.hasher.Write(value)
 	sum := th.hasher.Sum(nil)
 	th.hasher.Reset()
@@ -44,20 +90,75 @@ func (th *treeHasher) digestLeaf(path []byte, leafData []byte) ([]byte, []byte)
 	return sum, value
 }
 
-func (th *treeHasher) parseLeaf(data []byte) ([]byte, []byte) {
-	return data[len(leafPrefix) : th.pathSize()+len(leafPrefix)], data[len(leafPrefix)+th.pathSize():]
+// digestData returns the digest of serialized node data, i.e. the key under
+// which the node is stored.
+func (th *treeHasher) digestData(data []byte) []byte {
+	if th.isLeaf(data) {
+		th.hasher.Write(th.leafTag)
+		th.hasher.Write(th.leafSalt)
+	} else {
+		th.hasher.Write(th.innerTag)
+	}
+	return th.digest(data)
+}
+
+// checkLeaf checks that a path and value hash have the sizes expected of a
+// leaf.
+func (th *treeHasher) checkLeaf(path []byte, valueHash []byte) error {
+	if len(path) != th.pathSize() || len(valueHash) != th.hasher.Size() {
+		return ErrBadLeaf
+	}
+	return nil
+}
+
+func (th *treeHasher) parseLeaf(data []byte) ([]byte, []byte, error) {
+	if len(data) != th.leafSize() || th.checkFormatVersion(data) != nil {
+		return nil, nil, ErrBadLeaf
+	}
+	path, valueHash := th.codec.DecodeLeaf(data[len(th.formatVersion):], th.pathSize())
+	if err := th.checkLeaf(path, valueHash); err != nil {
+		return nil, nil, err
+	}
+	return path, valueHash, nil
 }
 
 func (th *treeHasher) isLeaf(data []byte) bool {
-	return bytes.Equal(data[:len(leafPrefix)], leafPrefix)
+	if len(data) < len(th.formatVersion) {
+		return false
+	}
+	return th.codec.IsLeaf(data[len(th.formatVersion):])
 }
 
-func (th *treeHasher) digestNode(leftData []byte, rightData []byte) ([]byte, []byte) {
-	value := make([]byte, 0, len(nodePrefix)+len(leftData)+len(rightData))
-	value = append(value, nodePrefix...)
-	value = append(value, leftData...)
-	value = append(value, rightData...)
+// withFormatVersion prepends the format version, if any, to serialized node
+// data.
+func (th *treeHasher) withFormatVersion(data []byte) []byte {
+	if th.formatVersion == nil {
+		return data
+	}
+	return append(append([]byte{}, th.formatVersion...), data...)
+}
 
+// checkFormatVersion returns ErrFormatVersionMismatch if serialized node data
+// does not begin with the format version of the tree.
+func (th *treeHasher) checkFormatVersion(data []byte) error {
+	if th.formatVersion == nil {
+		return nil
+	}
+	if len(data) == 0 || data[0] != th.formatVersion[0] {
+		return ErrFormatVersionMismatch
+	}
+	return nil
+}
+
+// digestNode serializes and hashes the inner node at the given depth, where
+// the root is at depth 0.
+func (th *treeHasher) digestNode(leftData []byte, rightData []byte, depth int) ([]byte, []byte) {
+	value := th.withFormatVersion(th.codec.EncodeInner(leftData, rightData))
+	if th.depthBound {
+		value = append(value, th.depthSuffix(depth)...)
+	}
+
+	th.hasher.Write(th.innerTag)
 	th.hasher.Write(value)
 	sum := th.hasher.Sum(nil)
 	th.hasher.Reset()
@@ -66,10 +167,41 @@ func (th *treeHasher) digestNode(leftData []byte, rightData []byte) ([]byte, []b
 }
 
 func (th *treeHasher) parseNode(data []byte) ([]byte, []byte) {
-	return data[len(nodePrefix) : th.pathSize()+len(nodePrefix)], data[len(nodePrefix)+th.pathSize():]
+	data = data[len(th.formatVersion):]
+	if th.depthBound {
+		data = data[:len(data)-depthSuffixSize]
+	}
+	return th.codec.DecodeInner(data, th.hasher.Size())
+}
+
+// depthSuffixSize is the size of the depth appended to inner nodes by
+// WithDepthBoundDigests.
+const depthSuffixSize = 2
+
+func (th *treeHasher) depthSuffix(depth int) []byte {
+	suffix := make([]byte, depthSuffixSize)
+	binary.BigEndian.PutUint16(suffix, uint16(depth))
+	return suffix
+}
+
+// leafSize returns the size of serialized leaf data.
+func (th *treeHasher) leafSize() int {
+	return len(th.formatVersion) + len(th.codec.EncodeLeaf(make([]byte, th.pathSize()), make([]byte, th.hasher.Size())))
+}
+
+// nodeSize returns the size of serialized inner node data.
+func (th *treeHasher) nodeSize() int {
+	size := len(th.formatVersion) + len(th.codec.EncodeInner(th.placeholder(), th.placeholder()))
+	if th.depthBound {
+		size += depthSuffixSize
+	}
+	return size
 }
 
 func (th *treeHasher) pathSize() int {
+	if th.pathLen > 0 {
+		return th.pathLen
+	}
 	return th.hasher.Size()
 }
 
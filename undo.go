@@ -0,0 +1,88 @@
+package smt
+
+import (
+	"errors"
+)
+
+// ErrNothingToUndo is returned by Undo and Redo when there is no operation to
+// undo or redo.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// undoEntry is an update recorded for Undo and Redo. For a tree with versioned
+// leaves, it also records the version the key had before the update, and the
+// versions of the tree before and after it; the key is set at the version of
+// the tree after the update, unless it was deleted.
+type undoEntry struct {
+	key, oldValue, newValue               []byte
+	oldKeyVersion, oldVersion, newVersion uint64
+}
+
+// recordUndo records an update of a key for Undo, and clears the operations
+// that could be redone.
+func (smt *SparseMerkleTree) recordUndo(entry undoEntry) {
+	entry.key = append([]byte(nil), entry.key...)
+	entry.oldValue = append([]byte(nil), entry.oldValue...)
+	entry.newValue = append([]byte(nil), entry.newValue...)
+	smt.undoStack = append(smt.undoStack, entry)
+	if len(smt.undoStack) > smt.undoLimit {
+		smt.undoStack = smt.undoStack[len(smt.undoStack)-smt.undoLimit:]
+	}
+	smt.redoStack = nil
+}
+
+// applyUndo sets the value of a key without recording it for Undo. For a tree
+// with versioned leaves, the key is set at keyVersion, and the version of the
+// tree is then set to version.
+func (smt *SparseMerkleTree) applyUndo(key []byte, value []byte, keyVersion, version uint64) error {
+	limit, treeVersion := smt.undoLimit, smt.version
+	smt.undoLimit = 0
+	defer func() { smt.undoLimit = limit }()
+	if smt.versionedLeaves && keyVersion > 0 {
+		// Update sets the key at the version following that of the tree.
+		smt.version = keyVersion - 1
+	}
+	if _, err := smt.Update(key, value); err != nil {
+		smt.version = treeVersion
+		return err
+	}
+	if smt.versionedLeaves {
+		smt.version = version
+	}
+	return nil
+}
+
+// Undo reverts the last Update or Delete of a tree created with WithUndo, by
+// setting the key back to its previous value, and for a tree with versioned
+// leaves, its previous version, and the previous version of the tree. Since
+// the root of the tree only depends on its contents, this restores the
+// previous root, except for a tree created with WithTombstones, where
+// reverting an insertion leaves a tombstone. ErrNothingToUndo is returned if
+// there is no operation to undo.
+func (smt *SparseMerkleTree) Undo() error {
+	if len(smt.undoStack) == 0 {
+		return ErrNothingToUndo
+	}
+	entry := smt.undoStack[len(smt.undoStack)-1]
+	if err := smt.applyUndo(entry.key, entry.oldValue, entry.oldKeyVersion, entry.oldVersion); err != nil {
+		return err
+	}
+	smt.undoStack = smt.undoStack[:len(smt.undoStack)-1]
+	smt.redoStack = append(smt.redoStack, entry)
+	return nil
+}
+
+// Redo applies again the last operation reverted by Undo. Any other update
+// clears the operations that can be redone. ErrNothingToUndo is returned if
+// there is no operation to redo.
+func (smt *SparseMerkleTree) Redo() error {
+	if len(smt.redoStack) == 0 {
+		return ErrNothingToUndo
+	}
+	entry := smt.redoStack[len(smt.redoStack)-1]
+	if err := smt.applyUndo(entry.key, entry.newValue, entry.newVersion, entry.newVersion); err != nil {
+		return err
+	}
+	smt.redoStack = smt.redoStack[:len(smt.redoStack)-1]
+	smt.undoStack = append(smt.undoStack, entry)
+	return nil
+}
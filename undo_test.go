@@ -0,0 +1,104 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestUndo(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithUndo(3))
+	if err := smt.Undo(); err != ErrNothingToUndo {
+		t.Error("did not return ErrNothingToUndo for tree without operations")
+	}
+
+	var roots [][]byte
+	roots = append(roots, smt.Root())
+	for i := 0; i < 5; i++ {
+		s := strconv.Itoa(i)
+		root, _ := smt.Update([]byte(s), []byte(s))
+		roots = append(roots, root)
+	}
+	root, _ := smt.Delete([]byte("2"))
+	roots = append(roots, root)
+	root, _ = smt.Update([]byte("0"), []byte("updated"))
+	roots = append(roots, root)
+
+	// Only the last 3 operations can be undone.
+	for i := len(roots) - 2; i >= len(roots)-4; i-- {
+		if err := smt.Undo(); err != nil {
+			t.Fatalf("returned error when undoing: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), roots[i]) {
+			t.Error("root after undo does not match previous root")
+		}
+	}
+	if err := smt.Undo(); err != ErrNothingToUndo {
+		t.Error("did not return ErrNothingToUndo beyond the limit")
+	}
+	value, _ := smt.Get([]byte("2"))
+	if !bytes.Equal(value, []byte("2")) {
+		t.Error("undoing delete did not restore value")
+	}
+
+	for i := len(roots) - 3; i < len(roots); i++ {
+		if err := smt.Redo(); err != nil {
+			t.Fatalf("returned error when redoing: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), roots[i]) {
+			t.Error("root after redo does not match")
+		}
+	}
+	if err := smt.Redo(); err != ErrNothingToUndo {
+		t.Error("did not return ErrNothingToUndo with nothing to redo")
+	}
+
+	// A new update clears the operations that can be redone.
+	smt.Undo()
+	smt.Update([]byte("5"), []byte("5"))
+	if err := smt.Redo(); err != ErrNothingToUndo {
+		t.Error("update did not clear the operations that can be redone")
+	}
+}
+
+// Test that Undo and Redo restore the roots of a tree with versioned leaves.
+func TestUndoVersionedLeaves(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithUndo(10), WithVersionedLeaves())
+	roots := [][]byte{smt.Root()}
+	versions := []uint64{smt.Version()}
+	for _, update := range []struct{ key, value string }{{"a", "1"}, {"a", "2"}, {"b", "1"}, {"a", ""}} {
+		root, err := smt.Update([]byte(update.key), []byte(update.value))
+		if err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+		roots = append(roots, root)
+		versions = append(versions, smt.Version())
+	}
+
+	for i := len(roots) - 2; i >= 0; i-- {
+		if err := smt.Undo(); err != nil {
+			t.Fatalf("returned error when undoing: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), roots[i]) || smt.Version() != versions[i] {
+			t.Errorf("root or version after undo does not match those at step %d", i)
+		}
+	}
+	for i := 1; i < len(roots); i++ {
+		if err := smt.Redo(); err != nil {
+			t.Fatalf("returned error when redoing: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), roots[i]) || smt.Version() != versions[i] {
+			t.Errorf("root or version after redo does not match those at step %d", i)
+		}
+	}
+}
+
+// Test that recording an operation for Undo does not record an access.
+func TestUndoAccessTracking(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithUndo(10), WithAccessTracking(10))
+	smt.Update([]byte("a"), []byte("a"))
+	if keys := smt.RecentKeys(); len(keys) != 1 {
+		t.Errorf("expected 1 recent key after one update, got: %d", len(keys))
+	}
+}
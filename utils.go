@@ -49,3 +49,14 @@ func reverseByteSlices(slices [][]byte) [][]byte {
 
 	return slices
 }
+
+// hasPrefixBits returns true if the first bits bits of data and prefix are
+// equal.
+func hasPrefixBits(data []byte, prefix []byte, bits int) bool {
+	for i := 0; i < bits; i++ {
+		if getBitAtFromMSB(data, i) != getBitAtFromMSB(prefix, i) {
+			return false
+		}
+	}
+	return true
+}
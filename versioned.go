@@ -0,0 +1,60 @@
+package smt
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// versionSize is the size of the version prefixed to values by
+// WithVersionedLeaves.
+const versionSize = 8
+
+// ErrBadVersionedValue is returned when a value stored by a tree with
+// versioned leaves is too short to hold a version.
+var ErrBadVersionedValue = errors.New("bad versioned value")
+
+// versionValue prefixes a value with the next version of the tree, if the
+// tree has versioned leaves. The version of the tree is advanced separately by
+// advanceVersion, once the value is set.
+func (smt *SparseMerkleTree) versionValue(value []byte) []byte {
+	if !smt.versionedLeaves {
+		return value
+	}
+	return encodeVersionedValue(value, smt.version+1)
+}
+
+// advanceVersion advances the version of a tree with versioned leaves, after a
+// value prefixed by versionValue is set.
+func (smt *SparseMerkleTree) advanceVersion() {
+	if smt.versionedLeaves {
+		smt.version++
+	}
+}
+
+func encodeVersionedValue(value []byte, version uint64) []byte {
+	versioned := make([]byte, versionSize+len(value))
+	binary.BigEndian.PutUint64(versioned, version)
+	copy(versioned[versionSize:], value)
+	return versioned
+}
+
+// splitVersion splits a stored value into its value and version.
+func splitVersion(versioned []byte) ([]byte, uint64, error) {
+	if len(versioned) < versionSize {
+		return nil, 0, ErrBadVersionedValue
+	}
+	return versioned[versionSize:], binary.BigEndian.Uint64(versioned), nil
+}
+
+// Version returns the version of the last update of a tree with versioned
+// leaves.
+func (smt *SparseMerkleTree) Version() uint64 {
+	return smt.version
+}
+
+// VerifyVersionedProof verifies a Merkle proof for a key of a tree with
+// versioned leaves, that the key has a value set at a version.
+func VerifyVersionedProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, version uint64, hasher hash.Hash, options ...Option) bool {
+	return VerifyProof(proof, root, key, encodeVersionedValue(value, version), hasher, options...)
+}
@@ -0,0 +1,113 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestVersionedLeaves(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithVersionedLeaves())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	value, version, err := smt.GetVersioned([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting versioned value: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) || version != 1 {
+		t.Errorf("unexpected value or version: %q, %d", value, version)
+	}
+	value, _ = smt.Get([]byte("testKey2"))
+	if !bytes.Equal(value, []byte("testValue2")) {
+		t.Error("did not get correct value when getting key")
+	}
+
+	// Setting the same value again changes the version and the root.
+	root := smt.Root()
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	_, version, _ = smt.GetVersioned([]byte("testKey"))
+	if version != 3 || smt.Version() != 3 {
+		t.Errorf("expected version 3, got: %d", version)
+	}
+	if bytes.Equal(root, smt.Root()) {
+		t.Error("updating version did not change root")
+	}
+
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyVersionedProof(proof, smt.Root(), []byte("testKey"), []byte("testValue"), 3, sha256.New()) {
+		t.Error("valid versioned proof failed to verify")
+	}
+	if VerifyVersionedProof(proof, smt.Root(), []byte("testKey"), []byte("testValue"), 1, sha256.New()) {
+		t.Error("versioned proof verified with stale version")
+	}
+
+	value, version, _ = smt.GetVersioned([]byte("absent"))
+	if !bytes.Equal(value, defaultValue) || version != 0 {
+		t.Error("unexpected value or version for absent key")
+	}
+
+	// The version continues from where a previous tree left off.
+	smt2 := ImportSparseMerkleTree(smt.nodes, smt.values, sha256.New(), smt.Root(), WithVersionedLeaves(), WithVersion(smt.Version()))
+	smt2.Update([]byte("testKey2"), []byte("testValue3"))
+	if _, version, _ = smt2.GetVersioned([]byte("testKey2")); version != 4 {
+		t.Errorf("expected version 4, got: %d", version)
+	}
+}
+
+// Test that UpdateMany sets each key at its own version, like Update.
+func TestVersionedLeavesUpdateMany(t *testing.T) {
+	keys := [][]byte{[]byte("testKey"), []byte("testKey2"), []byte("testKey3")}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithVersionedLeaves())
+	if err := smt.UpdateMany(keys, []byte("testValue")); err != nil {
+		t.Fatalf("returned error when updating keys: %v", err)
+	}
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithVersionedLeaves())
+	for _, key := range keys {
+		expected.Update(key, []byte("testValue"))
+	}
+	if !bytes.Equal(smt.Root(), expected.Root()) {
+		t.Error("root after UpdateMany does not match root after individual updates")
+	}
+	if smt.Version() != 3 {
+		t.Errorf("expected version 3, got: %d", smt.Version())
+	}
+	if _, version, _ := smt.GetVersioned(keys[1]); version != 2 {
+		t.Errorf("expected key to be set at version 2, got: %d", version)
+	}
+}
+
+// Test that a failed update does not advance the version.
+func TestVersionedLeavesFailedUpdate(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithVersionedLeaves())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	missingRoot := sha256.Sum256([]byte("missing"))
+	if _, err := smt.UpdateForRoot([]byte("testKey2"), []byte("testValue"), missingRoot[:]); err == nil {
+		t.Fatal("did not return error when updating from missing root")
+	}
+	if smt.Version() != 1 {
+		t.Errorf("expected version 1 after failed update, got: %d", smt.Version())
+	}
+}
+
+// Test that iterating a tree with versioned leaves returns values without
+// their version.
+func TestVersionedLeavesIterate(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithVersionedLeaves())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+
+	leaves, _, err := smt.IterateFrom(nil, 0)
+	if err != nil {
+		t.Fatalf("returned error when iterating: %v", err)
+	}
+	if len(leaves) != 1 || !bytes.Equal(leaves[0].Value, []byte("testValue")) {
+		t.Error("iteration did not return the value without its version")
+	}
+	leaves, err = smt.KeysWithPrefix(nil, 0)
+	if err != nil {
+		t.Fatalf("returned error when listing keys: %v", err)
+	}
+	if len(leaves) != 1 || !bytes.Equal(leaves[0].Value, []byte("testValue")) {
+		t.Error("KeysWithPrefix did not return the value without its version")
+	}
+}
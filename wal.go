@@ -0,0 +1,154 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrInvalidWAL is returned when replaying a write-ahead log with a corrupt
+// record.
+var ErrInvalidWAL = errors.New("invalid write-ahead log")
+
+// Operations recorded in a write-ahead log.
+const (
+	walOpUpdate byte = iota
+	walOpDelete
+	walOpDeletePath
+	walOpPurge
+)
+
+// WALTruncater is implemented by write-ahead logs that can be emptied, such
+// as *os.File.
+type WALTruncater interface {
+	Truncate(size int64) error
+}
+
+// writeWAL appends a record of an update, or a deletion if the value is the
+// default value, to the write-ahead log, if any.
+func (smt *SparseMerkleTree) writeWAL(key []byte, value []byte) error {
+	if smt.wal == nil {
+		return nil
+	}
+	var record bytes.Buffer
+	if smt.th.isDefault(value) {
+		record.WriteByte(walOpDelete)
+		if err := writeBytes(&record, key); err != nil {
+			return err
+		}
+	} else {
+		record.WriteByte(walOpUpdate)
+		if err := writeBytes(&record, key); err != nil {
+			return err
+		}
+		if err := writeBytes(&record, value); err != nil {
+			return err
+		}
+	}
+	return smt.appendWAL(record.Bytes())
+}
+
+// writeWALDeletePath appends a record of the deletion of a path to the
+// write-ahead log, if any.
+func (smt *SparseMerkleTree) writeWALDeletePath(path []byte) error {
+	return smt.writeWALOp(walOpDeletePath, path)
+}
+
+// writeWALPurge appends a record of the purge of a key to the write-ahead log,
+// if any.
+func (smt *SparseMerkleTree) writeWALPurge(key []byte) error {
+	return smt.writeWALOp(walOpPurge, key)
+}
+
+// writeWALOp appends a record of an operation on a key or path alone to the
+// write-ahead log, if any.
+func (smt *SparseMerkleTree) writeWALOp(op byte, key []byte) error {
+	if smt.wal == nil {
+		return nil
+	}
+	var record bytes.Buffer
+	record.WriteByte(op)
+	if err := writeBytes(&record, key); err != nil {
+		return err
+	}
+	return smt.appendWAL(record.Bytes())
+}
+
+// appendWAL appends a record to the write-ahead log.
+func (smt *SparseMerkleTree) appendWAL(record []byte) error {
+	// Write the record with its length in one call, so that a crash leaves at
+	// most one partial record at the end of the log.
+	var buf bytes.Buffer
+	if err := writeBytes(&buf, record); err != nil {
+		return err
+	}
+	_, err := smt.wal.Write(buf.Bytes())
+	return err
+}
+
+// truncateWAL empties the write-ahead log, if it implements WALTruncater.
+func (smt *SparseMerkleTree) truncateWAL() error {
+	truncater, ok := smt.wal.(WALTruncater)
+	if !ok {
+		return nil
+	}
+	return truncater.Truncate(0)
+}
+
+// ReplayWAL applies the updates, deletions and purges recorded in a
+// write-ahead log written by a tree created with WithWAL, in order, e.g. to a
+// tree imported at the last root persisted before a crash. The replayed
+// operations are not recorded again. A partial record at the end of the log,
+// left by a crash while it was written, is ignored; ErrInvalidWAL is returned
+// for a corrupt record.
+func (smt *SparseMerkleTree) ReplayWAL(r io.Reader) error {
+	wal := smt.wal
+	smt.wal = nil
+	defer func() { smt.wal = wal }()
+
+	br := bufio.NewReader(r)
+	for {
+		record, err := readBytes(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// The end of the log, or a partial record at the end of it.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rr := bufio.NewReader(bytes.NewReader(record))
+		op, err := rr.ReadByte()
+		if err != nil {
+			return ErrInvalidWAL
+		}
+		key, err := readBytes(rr)
+		if err != nil {
+			return ErrInvalidWAL
+		}
+		switch op {
+		case walOpUpdate:
+			var value []byte
+			if value, err = readBytes(rr); err != nil {
+				return ErrInvalidWAL
+			}
+			_, err = smt.Update(key, value)
+		case walOpDelete:
+			_, err = smt.Delete(key)
+		case walOpDeletePath:
+			// The key of the record is a path.
+			err = smt.DeletePath(key)
+		case walOpPurge:
+			_, err = smt.Purge(key)
+		default:
+			return ErrInvalidWAL
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := rr.ReadByte(); err != io.EOF {
+			return ErrInvalidWAL
+		}
+	}
+}
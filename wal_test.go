@@ -0,0 +1,125 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+// truncatingBuffer is a write-ahead log that can be truncated.
+type truncatingBuffer struct {
+	bytes.Buffer
+}
+
+func (tb *truncatingBuffer) Truncate(size int64) error {
+	tb.Buffer.Truncate(int(size))
+	return nil
+}
+
+func TestWAL(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	var wal truncatingBuffer
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithWAL(&wal))
+	for i := 0; i < 10; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	smt.Delete([]byte("3"))
+	smt.DeletePath(smt.Path([]byte("4")))
+	smt.UpdateMany([][]byte{[]byte("a"), []byte("b")}, []byte("many"))
+	smt.UpdatePreview([]byte("preview"), []byte("preview"))
+	root := smt.Root()
+
+	// Replay the log onto a tree that lost its root.
+	data := wal.Bytes()
+	recovered := NewSparseMerkleTree(smn, smv, sha256.New())
+	if err := recovered.ReplayWAL(bytes.NewReader(data)); err != nil {
+		t.Fatalf("returned error when replaying WAL: %v", err)
+	}
+	if !bytes.Equal(recovered.Root(), root) {
+		t.Error("root after replaying WAL does not match")
+	}
+
+	// A partial record at the end of the log is ignored.
+	recovered = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if err := recovered.ReplayWAL(bytes.NewReader(data[:len(data)-1])); err != nil {
+		t.Errorf("returned error when replaying WAL with partial record: %v", err)
+	}
+	has, _ := recovered.Has([]byte("b"))
+	if has {
+		t.Error("partial record was replayed")
+	}
+	has, _ = recovered.Has([]byte("a"))
+	if !has {
+		t.Error("complete record before partial record was not replayed")
+	}
+
+	// A corrupt record is rejected.
+	recovered = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if err := recovered.ReplayWAL(bytes.NewReader([]byte{2, 9, 0})); err != ErrInvalidWAL {
+		t.Errorf("did not return ErrInvalidWAL for corrupt record: %v", err)
+	}
+
+	// Replaying does not record the operations again, and closing truncates
+	// the log.
+	size := wal.Len()
+	if err := smt.ReplayWAL(bytes.NewReader(data)); err != nil {
+		t.Errorf("returned error when replaying WAL onto same tree: %v", err)
+	}
+	if wal.Len() != size {
+		t.Error("replayed operations were recorded")
+	}
+	if err := smt.Close(); err != nil {
+		t.Errorf("returned error when closing tree: %v", err)
+	}
+	if wal.Len() != 0 {
+		t.Error("closing tree did not truncate WAL")
+	}
+}
+
+// Test that operations rejected by the tree are not recorded, so that later
+// records are replayed.
+func TestWALRejectedOperation(t *testing.T) {
+	var wal bytes.Buffer
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithWAL(&wal), WithNoOrphanTracking())
+	smt.Update([]byte("a"), []byte("a"))
+	if _, err := smt.Delete([]byte("a")); err != ErrNoOrphanTracking {
+		t.Fatalf("did not return ErrNoOrphanTracking when deleting key: %v", err)
+	}
+	if err := smt.DeletePath(smt.Path([]byte("a"))); err != ErrNoOrphanTracking {
+		t.Fatalf("did not return ErrNoOrphanTracking when deleting path: %v", err)
+	}
+	smt.Update([]byte("b"), []byte("b"))
+
+	recovered := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithNoOrphanTracking())
+	if err := recovered.ReplayWAL(&wal); err != nil {
+		t.Fatalf("returned error when replaying WAL: %v", err)
+	}
+	if !bytes.Equal(recovered.Root(), smt.Root()) {
+		t.Error("root after replaying WAL does not match")
+	}
+}
+
+// Test that purges are recorded and replayed.
+func TestWALPurge(t *testing.T) {
+	var wal bytes.Buffer
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithWAL(&wal), WithTombstones())
+	for i := 0; i < 5; i++ {
+		s := strconv.Itoa(i)
+		smt.Update([]byte(s), []byte(s))
+	}
+	smt.Delete([]byte("1"))
+	if _, err := smt.Purge([]byte("1")); err != nil {
+		t.Fatalf("returned error when purging key: %v", err)
+	}
+	smt.Purge([]byte("2"))
+
+	recovered := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithTombstones())
+	if err := recovered.ReplayWAL(&wal); err != nil {
+		t.Fatalf("returned error when replaying WAL: %v", err)
+	}
+	if !bytes.Equal(recovered.Root(), smt.Root()) {
+		t.Error("root after replaying WAL with purges does not match")
+	}
+}